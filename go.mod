@@ -6,9 +6,11 @@ go 1.25.0
 require (
 	github.com/getsops/sops/v3 v3.12.1
 	github.com/go-git/go-git/v5 v5.17.0
+	github.com/google/cel-go v0.26.0
 	github.com/lithammer/dedent v1.1.0
 	github.com/stretchr/testify v1.11.1
 	golang.org/x/tools v0.43.0
+	google.golang.org/grpc v1.79.1
 	kcl-lang.io/krm-kcl v0.12.3
 	sigs.k8s.io/kustomize/api v0.21.1
 	sigs.k8s.io/kustomize/kyaml v0.21.1
@@ -123,7 +125,6 @@ require (
 	github.com/golang/groupcache v0.0.0-20241129210726-2c02b8208cf8 // indirect
 	github.com/golang/protobuf v1.5.4 // indirect
 	github.com/golang/snappy v0.0.4 // indirect
-	github.com/google/cel-go v0.26.0 // indirect
 	github.com/google/gnostic-models v0.7.0 // indirect
 	github.com/google/s2a-go v0.1.9 // indirect
 	github.com/google/uuid v1.6.0 // indirect
@@ -281,7 +282,6 @@ require (
 	golang.org/x/time v0.14.0 // indirect
 	google.golang.org/api v0.266.0 // indirect
 	google.golang.org/genproto v0.0.0-20260128011058-8636f8732409 // indirect
-	google.golang.org/grpc v1.79.1 // indirect
 	google.golang.org/protobuf v1.36.11 // indirect
 	gopkg.in/ini.v1 v1.67.1 // indirect
 	gopkg.in/warnings.v0 v0.1.2 // indirect
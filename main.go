@@ -127,6 +127,10 @@ func (p *processor) Process(rl *framework.ResourceList) error {
 		return fmt.Errorf("plugin %s is neither a generator nor a transformer", res.OrgId())
 	}
 
+	if reporter, ok := plugin.(plugins.ResultsReporter); ok {
+		rl.Results = append(rl.Results, reporter.Results()...)
+	}
+
 	return nil
 }
 
@@ -3,7 +3,6 @@ package extras
 import (
 	"fmt"
 
-	git "github.com/go-git/go-git/v5"
 	"sigs.k8s.io/kustomize/api/kv"
 	"sigs.k8s.io/kustomize/api/resmap"
 	"sigs.k8s.io/kustomize/api/types"
@@ -53,29 +52,20 @@ func (p *GitConfigMapGeneratorPlugin) Config(h *resmap.PluginHelpers, config []b
 func (p *GitConfigMapGeneratorPlugin) Generate() (resmap.ResMap, error) {
 	// Add git repository properties
 
-	repo, err := git.PlainOpenWithOptions(p.h.Loader().Root(), &git.PlainOpenOptions{DetectDotGit: true})
+	repo, err := openGitRepository(p.h.Loader().Root())
 	if err != nil {
 		return resmap.New(), errors.WrapPrefixf(err, "opening git repo")
 	}
-	remoteName := p.RemoteName
-	if remoteName == "" {
-		remoteName = "origin"
-	}
-	origin, err := repo.Remote(remoteName)
+	remoteURL, err := repo.remoteURL(p.RemoteName)
 	if err != nil {
-		return resmap.New(), errors.WrapPrefixf(err, "getting remote %s", remoteName)
+		return resmap.New(), errors.WrapPrefixf(err, "getting remote")
 	}
 
 	p.LiteralSources = append(p.LiteralSources,
-		fmt.Sprintf("repoURL=%s", origin.Config().URLs[0]))
-
-	head, err := repo.Head()
-	if err != nil {
-		return resmap.New(), errors.WrapPrefixf(err, "getting current branch")
-	}
+		fmt.Sprintf("repoURL=%s", remoteURL))
 
 	p.LiteralSources = append(p.LiteralSources,
-		fmt.Sprintf("targetRevision=%s", head.Name().Short()))
+		fmt.Sprintf("targetRevision=%s", repo.head.Name().Short()))
 
 	var result resmap.ResMap
 	result, err = p.h.ResmapFactory().FromConfigMapArgs(
@@ -4,12 +4,11 @@ import (
 	"fmt"
 
 	"sigs.k8s.io/kustomize/api/resmap"
-	"sigs.k8s.io/kustomize/api/types"
 	"sigs.k8s.io/yaml"
 )
 
 type RemoveTransformerPlugin struct {
-	Targets []*types.Selector `json:"targets,omitempty" yaml:"targets,omitempty"`
+	Targets []*Selector `json:"targets,omitempty" yaml:"targets,omitempty"`
 }
 
 func (p *RemoveTransformerPlugin) Config(_ *resmap.PluginHelpers, c []byte) error {
@@ -17,6 +16,9 @@ func (p *RemoveTransformerPlugin) Config(_ *resmap.PluginHelpers, c []byte) erro
 	if err != nil {
 		return fmt.Errorf("while configuring RemoveTransformerPlugin: %w", err)
 	}
+	if err = compileSelectors(p.Targets); err != nil {
+		return fmt.Errorf("while compiling RemoveTransformerPlugin targets: %w", err)
+	}
 	return nil
 }
 
@@ -25,11 +27,18 @@ func (p *RemoveTransformerPlugin) Transform(m resmap.ResMap) error {
 		return fmt.Errorf("must specify at least one target")
 	}
 	for _, t := range p.Targets {
-		resources, err := m.Select(*t)
+		resources, err := m.Select(t.Selector)
 		if err != nil {
 			return fmt.Errorf("while selecting target %s: %w", t.String(), err)
 		}
 		for _, r := range resources {
+			matches, err := t.matchesContent(&r.RNode)
+			if err != nil {
+				return fmt.Errorf("while matching target %s: %w", t.String(), err)
+			}
+			if !matches {
+				continue
+			}
 			err = m.Remove(r.CurId())
 			if err != nil {
 				return fmt.Errorf("while removing resource %s: %w", r.CurId().String(), err)
@@ -0,0 +1,95 @@
+package extras_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+
+	"github.com/karmafun/karmafun/pkg/extras"
+	"github.com/karmafun/karmafun/pkg/plugins"
+	"github.com/karmafun/karmafun/pkg/utils"
+)
+
+func TestRemoveTransformer(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		target    string
+		resources string
+		want      []string
+		wantErr   string
+		// wantConfigErr is the expected error of the configuration.
+		wantConfigErr string
+	}{
+		{
+			name:   "kind",
+			target: "{kind: PlatformValues}",
+			want:   []string{"revisions", "app-a", "app-b", "app-c"},
+		},
+		{
+			name:   "field selector",
+			target: "{kind: Application, fieldSelector: metadata.labels.team}",
+			want:   []string{"app-a", "app-b", "revisions", "app-c"},
+		},
+		{
+			name:   "cel selector",
+			target: "{celSelector: \"resource.kind == 'Application' && resource.metadata.name != 'app-b'\"}",
+			want:   []string{"app-a", "app-b", "revisions", "app-b"},
+		},
+		{
+			name:      "path selector",
+			target:    "{pathSelector: 'applications/prod/**'}",
+			resources: pathResources,
+			want:      []string{"app-c", "app-d"},
+		},
+		{
+			name:          "invalid field selector",
+			target:        "{fieldSelector: 'metadata.name~=['}",
+			wantConfigErr: "while compiling field selector regex",
+		},
+		{
+			name:          "invalid path selector",
+			target:        "{pathSelector: 'applications/[prod'}",
+			wantConfigErr: "unterminated character class",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := require.New(t)
+
+			helpers, err := plugins.NewPluginHelpers()
+			req.NoError(err)
+			p, ok := extras.NewRemoveTransformerPlugin().(*extras.RemoveTransformerPlugin)
+			req.True(ok)
+			err = p.Config(helpers, fmt.Appendf(nil, "targets: [%s]", tt.target))
+			if tt.wantConfigErr != "" {
+				req.ErrorContains(err, tt.wantConfigErr)
+				return
+			}
+			req.NoError(err)
+
+			resources := tt.resources
+			if resources == "" {
+				resources = correlatedResources
+			}
+			nodes, err := kio.FromBytes([]byte(resources))
+			req.NoError(err)
+			rm := utils.ResourceMapFromNodes(nodes)
+
+			err = p.Transform(rm)
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			var names []string
+			for _, r := range rm.Resources() {
+				names = append(names, r.GetName())
+			}
+			req.Equal(tt.want, names)
+		})
+	}
+}
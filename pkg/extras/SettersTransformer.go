@@ -0,0 +1,334 @@
+package extras
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	kyaml_utils "sigs.k8s.io/kustomize/kyaml/utils"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// SetterMarker is the comment prefix marking the fields set by the
+	// [SettersTransformerPlugin].
+	SetterMarker = "karmafun-set:"
+
+	defaultSetterFieldPath = "data"
+)
+
+// setterReferenceRegexp matches the setter references of a marker.
+var setterReferenceRegexp = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// SettersTransformerPlugin sets the value of the fields marked with a
+// comment like:
+//
+//	repoURL: https://github.com/upstream/app.git # karmafun-set: ${repoURL}
+//	image: nginx:1.25 # karmafun-set: ${image}:${tag}
+//
+// The field value becomes the marker pattern with the setter references
+// replaced by their value. A sequence field marked with a single reference
+// is replaced by the elements of its setter:
+//
+//	args: # karmafun-set: ${args}
+//	  - --verbose
+//
+// Markers are also honored in the YAML embedded in string fields, like Helm
+// values.
+//
+// The setter values are given inline by Setters or read in the resources
+// selected by ValuesFrom. The resources are selected in the transformed
+// resources, like an injected LocalConfiguration, or in the resource files of
+// Source. The mappings of the selected field, data by default, are flattened
+// into dotted names, like the ones of Setters. Inline setters take
+// precedence, followed by the last ValuesFrom entries.
+//
+// Fields referencing a setter without value are left unchanged and reported
+// with a warning. So are the string fields containing a marker that are not
+// valid YAML.
+type SettersTransformerPlugin struct {
+	h          *resmap.PluginHelpers
+	Setters    map[string]any    `json:"setters,omitempty"    yaml:"setters,omitempty"`
+	ValuesFrom []*SourceSelector `json:"valuesFrom,omitempty" yaml:"valuesFrom,omitempty"`
+	Source     SourcePaths       `json:"source,omitempty"     yaml:"source,omitempty"`
+	results    framework.Results
+}
+
+// Config configures the plugin.
+func (p *SettersTransformerPlugin) Config(h *resmap.PluginHelpers, c []byte) error {
+	if err := yaml.Unmarshal(c, p); err != nil {
+		return fmt.Errorf("while configuring SettersTransformerPlugin: %w", err)
+	}
+	if len(p.Source) > 0 && len(p.ValuesFrom) == 0 {
+		return fmt.Errorf("source needs valuesFrom to select the resources containing the setter values")
+	}
+	p.h = h
+	return nil
+}
+
+// setterValues loads the values of the setters.
+func (p *SettersTransformerPlugin) setterValues(m resmap.ResMap) (map[string]*yaml.Node, error) {
+	values := map[string]*yaml.Node{}
+	if len(p.ValuesFrom) > 0 {
+		nodes := m.ToRNodeSlice()
+		if len(p.Source) > 0 {
+			sources, err := loadSourceLayers(p.h, p.Source)
+			if err != nil {
+				return nil, fmt.Errorf("while loading source from path %s: %w", strings.Join(p.Source, ", "), err)
+			}
+			nodes = sources.nodes
+		}
+		for _, selector := range p.ValuesFrom {
+			source, err := selectSourceNode(nodes, nil, selector)
+			if err != nil {
+				return nil, fmt.Errorf("while selecting setter values: %w", err)
+			}
+			fieldPath := selector.FieldPath
+			if fieldPath == "" {
+				fieldPath = defaultSetterFieldPath
+			}
+			field, err := source.Pipe(yaml.Lookup(kyaml_utils.SmarterPathSplitter(fieldPath, ".")...))
+			if err != nil {
+				return nil, fmt.Errorf("while looking up setter values %s: %w", fieldPath, err)
+			}
+			if field == nil || field.YNode().Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("field %s of setter values %s is not a mapping", fieldPath, selector)
+			}
+			flattenSetterValues(values, "", field.YNode())
+		}
+	}
+	if len(p.Setters) > 0 {
+		node, err := yaml.FromMap(p.Setters)
+		if err != nil {
+			return nil, fmt.Errorf("while reading setters: %w", err)
+		}
+		flattenSetterValues(values, "", node.YNode())
+	}
+	return values, nil
+}
+
+// flattenSetterValues adds the values of mapping to values. The values of
+// nested mappings are named after their dotted path.
+func flattenSetterValues(values map[string]*yaml.Node, prefix string, mapping *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		name, value := prefix+mapping.Content[i].Value, mapping.Content[i+1]
+		if value.Kind == yaml.MappingNode {
+			flattenSetterValues(values, name+".", value)
+			continue
+		}
+		values[name] = value
+	}
+}
+
+// Transform sets the marked fields of the resources in m.
+func (p *SettersTransformerPlugin) Transform(m resmap.ResMap) error {
+	p.results = nil
+	values, err := p.setterValues(m)
+	if err != nil {
+		return err
+	}
+	for _, r := range m.Resources() {
+		s := &setterWalker{values: values, resource: &r.RNode}
+		if err = s.walk(r.YNode(), nil); err != nil {
+			return fmt.Errorf("while applying setters to %s: %w", r.CurId(), err)
+		}
+		p.results = append(p.results, s.results...)
+	}
+	return nil
+}
+
+// Results returns the fields set by the last transformation and the markers
+// referencing setters without value.
+func (p *SettersTransformerPlugin) Results() framework.Results {
+	return p.results
+}
+
+// NewSettersTransformerPlugin returns a newly created [SettersTransformerPlugin].
+func NewSettersTransformerPlugin() resmap.TransformerPlugin {
+	return &SettersTransformerPlugin{}
+}
+
+// setterWalker applies the setters to the fields of a resource.
+type setterWalker struct {
+	values   map[string]*yaml.Node
+	resource *yaml.RNode
+	results  framework.Results
+}
+
+// markerPattern returns the pattern of the setter marker in comment, if any.
+func markerPattern(comment string) (string, bool) {
+	comment = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(comment), "#"))
+	pattern, found := strings.CutPrefix(comment, SetterMarker)
+	return strings.TrimSpace(pattern), found
+}
+
+// walk applies the setters to node and its descendants. path is the path of
+// node in the resource.
+func (s *setterWalker) walk(node *yaml.Node, path []string) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			if err := s.walk(child, path); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if err := s.visit(value, key.LineComment, slices.Concat(path, []string{key.Value})); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			if err := s.visit(item, "", slices.Concat(path, []string{strconv.Itoa(i)})); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// visit applies the setters to the field node. keyComment is the line comment
+// of the field key, where the markers of sequences are placed.
+func (s *setterWalker) visit(node *yaml.Node, keyComment string, path []string) error {
+	pattern, found := markerPattern(node.LineComment)
+	if !found {
+		pattern, found = markerPattern(keyComment)
+	}
+	switch {
+	case found:
+		return s.set(node, pattern, path)
+	case node.Kind == yaml.ScalarNode && strings.Contains(node.Value, SetterMarker):
+		return s.setEmbedded(node, path)
+	default:
+		return s.walk(node, path)
+	}
+}
+
+// set sets the value of the field node from the marker pattern.
+func (s *setterWalker) set(node *yaml.Node, pattern string, path []string) error {
+	var names, missing []string
+	for _, match := range setterReferenceRegexp.FindAllStringSubmatch(pattern, -1) {
+		names = append(names, match[1])
+		if _, ok := s.values[match[1]]; !ok {
+			missing = append(missing, match[1])
+		}
+	}
+	fieldPath := strings.Join(path, ".")
+	if len(names) == 0 {
+		return fmt.Errorf("setter marker %q of field %s references no setter", pattern, fieldPath)
+	}
+	if len(missing) > 0 {
+		s.results = append(s.results, &framework.Result{
+			Message:     fmt.Sprintf("field %s references setter(s) without value: %s", fieldPath, strings.Join(missing, ", ")),
+			Severity:    framework.Warning,
+			ResourceRef: resourceRef(s.resource),
+			Field:       &framework.Field{Path: fieldPath},
+			File:        resourceFile(s.resource),
+		})
+		return nil
+	}
+
+	previous := strings.TrimSuffix(yaml.NewRNode(node).MustString(), "\n")
+	switch node.Kind {
+	case yaml.SequenceNode:
+		if err := s.setSequence(node, pattern, fieldPath); err != nil {
+			return err
+		}
+	case yaml.ScalarNode:
+		for _, name := range names {
+			if s.values[name].Kind != yaml.ScalarNode {
+				return fmt.Errorf("setter %s of scalar field %s is not a scalar", name, fieldPath)
+			}
+		}
+		value := setterReferenceRegexp.ReplaceAllStringFunc(pattern, func(reference string) string {
+			return s.values[setterReferenceRegexp.FindStringSubmatch(reference)[1]].Value
+		})
+		if node.Value == value {
+			return nil
+		}
+		node.Value = value
+		if node.Tag != yaml.NodeTagString {
+			// let the new value define its type
+			node.Tag = ""
+		}
+	default:
+		return fmt.Errorf("setter marker of field %s can only be used on scalars and sequences", fieldPath)
+	}
+
+	current := strings.TrimSuffix(yaml.NewRNode(node).MustString(), "\n")
+	if current == previous {
+		return nil
+	}
+	s.results = append(s.results, &framework.Result{
+		Message:     fmt.Sprintf("field %s set by setter(s) %s", fieldPath, strings.Join(names, ", ")),
+		Severity:    framework.Info,
+		ResourceRef: resourceRef(s.resource),
+		Field:       &framework.Field{Path: fieldPath, CurrentValue: previous, ProposedValue: current},
+		File:        resourceFile(s.resource),
+		Tags:        map[string]string{"setters": strings.Join(names, ",")},
+	})
+	return nil
+}
+
+// setSequence replaces the elements of the sequence node with the elements
+// of the setter referenced by pattern. The setter value is either a sequence
+// or a string containing a YAML sequence.
+func (s *setterWalker) setSequence(node *yaml.Node, pattern, fieldPath string) error {
+	match := setterReferenceRegexp.FindStringSubmatch(pattern)
+	if match[0] != pattern {
+		return fmt.Errorf("setter marker of sequence %s must reference a single setter", fieldPath)
+	}
+	value := s.values[match[1]]
+	if value.Kind == yaml.ScalarNode {
+		parsed, err := yaml.Parse(value.Value)
+		if err != nil {
+			return fmt.Errorf("while parsing setter %s: %w", match[1], err)
+		}
+		value = parsed.YNode()
+	}
+	if value.Kind != yaml.SequenceNode {
+		return fmt.Errorf("setter %s of sequence %s is not a sequence", match[1], fieldPath)
+	}
+	node.Content = yaml.CopyYNode(value).Content
+	return nil
+}
+
+// setEmbedded applies the setters to the YAML document embedded in the
+// scalar node.
+func (s *setterWalker) setEmbedded(node *yaml.Node, path []string) error {
+	extender := &yamlExtender{}
+	if err := extender.SetPayload([]byte(node.Value)); err != nil {
+		// the marker may only be part of some text
+		fieldPath := strings.Join(path, ".")
+		s.results = append(s.results, &framework.Result{
+			Message:     fmt.Sprintf("field %s contains a setter marker but is not valid YAML: %v", fieldPath, err),
+			Severity:    framework.Warning,
+			ResourceRef: resourceRef(s.resource),
+			Field:       &framework.Field{Path: fieldPath},
+			File:        resourceFile(s.resource),
+		})
+		return nil
+	}
+	before := len(s.results)
+	if err := s.walk(extender.node.YNode(), slices.Concat(path, []string{"!!yaml"})); err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(s.results[before:], func(r *framework.Result) bool {
+		return r.Severity == framework.Info
+	}) {
+		// nothing has been set
+		return nil
+	}
+	payload, err := extender.GetPayload()
+	if err != nil {
+		return fmt.Errorf("while serializing embedded YAML of field %s: %w", strings.Join(path, "."), err)
+	}
+	node.Value = string(payload)
+	return nil
+}
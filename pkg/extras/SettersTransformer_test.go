@@ -0,0 +1,211 @@
+package extras_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+
+	"github.com/karmafun/karmafun/pkg/extras"
+)
+
+const settersResources = `apiVersion: config.karmafun.dev/v1alpha1
+kind: LocalConfiguration
+metadata:
+  name: setters
+data:
+  repoURL: https://github.com/karmafun/karmafun.git
+  image:
+    name: nginx
+    tag: "1.27"
+  args: [--verbose, --port=8080]
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: app
+spec:
+  source:
+    repoURL: https://github.com/upstream/app.git # karmafun-set: ${repoURL}
+    targetRevision: main # karmafun-set: ${targetRevision}
+    helm:
+      values: |
+        image: nginx:1.25 # karmafun-set: ${image.name}:${image.tag}
+        replicas: 1 # karmafun-set: ${replicas}
+        args: # karmafun-set: ${args}
+          - --quiet
+`
+
+// runSettersTransformer configures a setters transformer with config,
+// applies it to input and returns the resulting resources.
+func runSettersTransformer(t *testing.T, config, input string) (string, framework.Results, error) {
+	t.Helper()
+	p := &extras.SettersTransformerPlugin{}
+	nodes, err := transformResources(t, p, config, input)
+	if err != nil {
+		return "", p.Results(), err
+	}
+	return resourcesString(t, nodes), p.Results(), nil
+}
+
+func TestSettersTransformer(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	valuesPath := filepath.Join(dir, "values.yaml")
+	require.NoError(t, os.WriteFile(valuesPath, []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: values
+data:
+  targetRevision: deploy/prod
+  replicas: "3"
+`), 0o600))
+
+	tests := []struct {
+		name        string
+		config      string
+		input       string
+		want        []string
+		wantWarning string
+		wantErr     string
+	}{
+		{
+			name: "inline setters",
+			config: `
+setters:
+  repoURL: https://github.com/karmafun/karmafun.git
+  targetRevision: deploy/citest
+`,
+			want: []string{
+				"    repoURL: https://github.com/karmafun/karmafun.git # karmafun-set: ${repoURL}\n",
+				"    targetRevision: deploy/citest # karmafun-set: ${targetRevision}\n",
+				"        image: nginx:1.25 # karmafun-set: ${image.name}:${image.tag}\n",
+			},
+			wantWarning: "references setter(s) without value: image.name, image.tag",
+		},
+		{
+			name: "local configuration and embedded yaml",
+			config: `
+valuesFrom:
+  - {kind: LocalConfiguration, name: setters}
+setters:
+  targetRevision: deploy/citest
+  replicas: 2
+`,
+			want: []string{
+				"    repoURL: https://github.com/karmafun/karmafun.git # karmafun-set: ${repoURL}\n",
+				"    targetRevision: deploy/citest # karmafun-set: ${targetRevision}\n",
+				"        image: nginx:1.27 # karmafun-set: ${image.name}:${image.tag}\n",
+				"        replicas: 2 # karmafun-set: ${replicas}\n",
+				"        args: # karmafun-set: ${args}\n          - --verbose\n          - --port=8080\n",
+			},
+		},
+		{
+			name: "source file",
+			config: `
+source: ` + valuesPath + `
+valuesFrom:
+  - {kind: ConfigMap, name: values}
+setters:
+  repoURL: https://github.com/karmafun/karmafun.git
+  image.name: nginx
+  image.tag: "1.28"
+  args: "[--debug]"
+`,
+			want: []string{
+				"    targetRevision: deploy/prod # karmafun-set: ${targetRevision}\n",
+				"        image: nginx:1.28 # karmafun-set: ${image.name}:${image.tag}\n",
+				"        replicas: 3 # karmafun-set: ${replicas}\n",
+				"        args: # karmafun-set: ${args}\n          - --debug\n",
+			},
+		},
+		{
+			name: "nested inline setters",
+			config: `
+setters:
+  image:
+    name: nginx
+    tag: "1.28"
+  args: [--debug]
+`,
+			want: []string{
+				"        image: nginx:1.28 # karmafun-set: ${image.name}:${image.tag}\n",
+				"        args: # karmafun-set: ${args}\n          - --debug\n",
+			},
+			wantWarning: "references setter(s) without value: repoURL",
+		},
+		{
+			name: "marker in text",
+			config: `
+setters:
+  name: value
+`,
+			input: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: notes
+data:
+  notes: "mark the field with a comment like: karmafun-set: ${name}"
+`,
+			want: []string{
+				`  notes: "mark the field with a comment like: karmafun-set: ${name}"` + "\n",
+			},
+			wantWarning: "field data.notes contains a setter marker but is not valid YAML",
+		},
+		{
+			name: "values are not a mapping",
+			config: `
+valuesFrom:
+  - {kind: LocalConfiguration, name: setters, fieldPath: data.repoURL}
+`,
+			wantErr: "field data.repoURL of setter values",
+		},
+		{
+			name: "scalar setter on a sequence",
+			config: `
+setters:
+  args: --verbose
+`,
+			wantErr: "setter args of sequence spec.source.helm.values.!!yaml.args is not a sequence",
+		},
+		{
+			name:    "source without valuesFrom",
+			config:  "source: " + valuesPath,
+			wantErr: "source needs valuesFrom",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := require.New(t)
+			input := tt.input
+			if input == "" {
+				input = settersResources
+			}
+			got, results, err := runSettersTransformer(t, tt.config, input)
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			for _, want := range tt.want {
+				req.Contains(got, want)
+			}
+
+			var warnings []string
+			for _, r := range results {
+				if r.Severity == framework.Warning {
+					warnings = append(warnings, r.Message)
+				}
+			}
+			if tt.wantWarning == "" {
+				req.Empty(warnings)
+				return
+			}
+			req.Contains(strings.Join(warnings, "\n"), tt.wantWarning)
+		})
+	}
+}
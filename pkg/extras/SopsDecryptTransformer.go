@@ -0,0 +1,163 @@
+package extras
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/getsops/sops/v3/cmd/sops/formats"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/api/resource"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/karmafun/karmafun/pkg/utils"
+)
+
+// SopsDecryptTransformerPlugin decrypts the sops encrypted resources, i.e.
+// the resources containing a sops field, selected by Targets. All the
+// encrypted resources are decrypted if no target is specified. The message
+// authentication code of the resources is verified.
+//
+// In order to prevent the decrypted content from being written to disk, the
+// decrypted resources are marked as local configuration, to be pruned by a
+// later function. If Reencrypt is true, they are instead marked to be
+// encrypted again by a later [SopsEncryptTransformerPlugin]. In both cases,
+// their path and index are moved to the config.karmafun.dev/path and
+// config.karmafun.dev/index annotations, so that they are never written back
+// in place in clear text.
+//
+// The data keys are decrypted with the keys of KeySources, like with the
+// [SopsGeneratorPlugin].
+type SopsDecryptTransformerPlugin struct {
+	h          *resmap.PluginHelpers
+	Targets    []*Selector     `json:"targets,omitempty"    yaml:"targets,omitempty"`
+	Reencrypt  bool            `json:"reencrypt,omitempty"  yaml:"reencrypt,omitempty"`
+	KeySources *SopsKeySources `json:"keySources,omitempty" yaml:"keySources,omitempty"`
+	results    framework.Results
+}
+
+// Config configures the plugin.
+func (p *SopsDecryptTransformerPlugin) Config(h *resmap.PluginHelpers, c []byte) error {
+	if err := yaml.Unmarshal(c, p); err != nil {
+		return fmt.Errorf("while configuring SopsDecryptTransformerPlugin: %w", err)
+	}
+	if err := compileSelectors(p.Targets); err != nil {
+		return fmt.Errorf("while compiling SopsDecryptTransformerPlugin targets: %w", err)
+	}
+	p.h = h
+	return nil
+}
+
+// selectResources returns the encrypted resources of m selected by the
+// targets, in the order of m.
+func (p *SopsDecryptTransformerPlugin) selectResources(m resmap.ResMap) ([]*resource.Resource, error) {
+	selected := map[*resource.Resource]bool{}
+	for _, r := range m.Resources() {
+		selected[r] = len(p.Targets) == 0
+	}
+	for _, t := range p.Targets {
+		resources, err := m.Select(t.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("while selecting target %s: %w", t.String(), err)
+		}
+		for _, r := range resources {
+			matches, err := t.matchesContent(&r.RNode)
+			if err != nil {
+				return nil, fmt.Errorf("while matching target %s: %w", t.String(), err)
+			}
+			selected[r] = selected[r] || matches
+		}
+	}
+	return slices.DeleteFunc(m.Resources(), func(r *resource.Resource) bool {
+		return !selected[r] || !isSopsEncrypted(&r.RNode)
+	}), nil
+}
+
+// Transform decrypts the selected resources of m. The resources are only
+// modified if all of them are decrypted.
+func (p *SopsDecryptTransformerPlugin) Transform(m resmap.ResMap) error {
+	p.results = nil
+	resources, err := p.selectResources(m)
+	if err != nil {
+		return err
+	}
+	if len(resources) == 0 {
+		return nil
+	}
+	keys, err := p.KeySources.keyRing(p.h.Loader().Root())
+	if err != nil {
+		return fmt.Errorf("while configuring key sources: %w", err)
+	}
+	defer keys.close()
+
+	decrypted := make([]*kyaml.RNode, len(resources))
+	var errs []error
+	for i, r := range resources {
+		if decrypted[i], err = p.decrypt(&r.RNode, keys); err != nil {
+			errs = append(errs, fmt.Errorf("while decrypting %s: %w", r.CurId(), err))
+		}
+	}
+	if len(errs) > 0 {
+		p.results = nil
+		return errors.Join(errs...)
+	}
+	for i, r := range resources {
+		r.SetYNode(decrypted[i].YNode())
+	}
+	return nil
+}
+
+// Results returns the resources decrypted by the last transformation.
+func (p *SopsDecryptTransformerPlugin) Results() framework.Results {
+	return p.results
+}
+
+// NewSopsDecryptTransformerPlugin returns a newly created [SopsDecryptTransformerPlugin].
+func NewSopsDecryptTransformerPlugin() resmap.TransformerPlugin {
+	return &SopsDecryptTransformerPlugin{}
+}
+
+// decrypt returns the decrypted version of n, marked according to the
+// configuration. The data key is decrypted with keys.
+func (p *SopsDecryptTransformerPlugin) decrypt(n *kyaml.RNode, keys *sopsKeyRing) (*kyaml.RNode, error) {
+	encrypted := n.Copy()
+	transient, err := stripTransientAnnotations(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	text, err := encrypted.String()
+	if err != nil {
+		return nil, fmt.Errorf("while serializing resource: %w", err)
+	}
+	path, err := resourcePath(n)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := decryptResources([]byte(text), formats.Yaml, path, false, keys)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) != 1 {
+		return nil, fmt.Errorf("decrypted resource contains %d documents", len(nodes))
+	}
+
+	result := nodes[0]
+	marker, message := utils.FunctionAnnotationLocalConfig, "decrypted as local configuration"
+	if p.Reencrypt {
+		marker, message = utils.FunctionAnnotationSopsReencrypt, "decrypted for re-encryption"
+	}
+	transient[marker] = "true"
+	holdFileAnnotations(transient)
+	if err = restoreAnnotations(result, transient); err != nil {
+		return nil, err
+	}
+	p.results = append(p.results, &framework.Result{
+		Message:     message,
+		Severity:    framework.Info,
+		ResourceRef: resourceRef(n),
+		File:        resourceFile(n),
+	})
+	return result, nil
+}
@@ -0,0 +1,76 @@
+package extras_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/karmafun/karmafun/pkg/extras"
+	"github.com/karmafun/karmafun/pkg/utils"
+)
+
+// runSopsDecryptTransformer applies a sops decryption transformer configured
+// with config to input and returns the resulting resources.
+func runSopsDecryptTransformer(t *testing.T, config, input string) ([]*yaml.RNode, error) {
+	t.Helper()
+	return transformResources(t, &extras.SopsDecryptTransformerPlugin{}, config, input)
+}
+
+func TestSopsDecryptTransformer(t *testing.T) {
+	t.Setenv("SOPS_AGE_KEY", sopsTestAgeKey)
+	req := require.New(t)
+	dir := sopsTestDir(t)
+	path := filepath.Join(dir, "credentials.yaml")
+
+	nodes, _, err := runSopsEncryptTransformer(t, "", sopsTestSecret(path, "secret"))
+	req.NoError(err)
+	encrypted := nodes[0].MustString()
+	saved := saveResource(t, nodes[0], path)
+
+	// local configuration
+	nodes, err = runSopsDecryptTransformer(t, "", encrypted)
+	req.NoError(err)
+	decrypted := nodes[0].MustString()
+	req.Contains(decrypted, "  password: secret\n")
+	req.NotContains(decrypted, "sops:")
+	req.Equal("true", nodes[0].GetAnnotations()[utils.FunctionAnnotationLocalConfig])
+	annotations := nodes[0].GetAnnotations()
+	req.NotContains(annotations, kioutil.PathAnnotation, "decrypted resource should not be written in place")
+	req.Equal(path, annotations[utils.FunctionAnnotationPath])
+
+	// not selected
+	nodes, err = runSopsDecryptTransformer(t, "targets: [{kind: Secret, name: other}]", encrypted)
+	req.NoError(err)
+	req.Equal(encrypted, nodes[0].MustString())
+
+	// re-encryption
+	nodes, err = runSopsDecryptTransformer(t, "reencrypt: true", encrypted)
+	req.NoError(err)
+	req.Equal("true", nodes[0].GetAnnotations()[utils.FunctionAnnotationSopsReencrypt])
+	req.NotContains(nodes[0].GetAnnotations(), utils.FunctionAnnotationLocalConfig)
+	req.NotContains(nodes[0].GetAnnotations(), kioutil.PathAnnotation)
+	// the orchestrator gives a default path to the resources without path
+	req.NoError(nodes[0].PipeE(yaml.SetAnnotation(kioutil.PathAnnotation, "secret_credentials.yaml")))
+	nodes, _, err = runSopsEncryptTransformer(t, "targets: [{kind: ConfigMap}]", nodes[0].MustString())
+	req.NoError(err)
+	req.Equal(path, nodes[0].GetAnnotations()[kioutil.PathAnnotation], "re-encrypted resource should go back in place")
+	req.NotContains(nodes[0].GetAnnotations(), utils.FunctionAnnotationPath)
+	req.Equal(saved, saveResource(t, nodes[0], path), "re-encrypted resource should be unchanged")
+
+	// tampered resource
+	_, err = runSopsDecryptTransformer(t, "", strings.Replace(encrypted, "type: Opaque", "type: Tampered", 1))
+	req.ErrorContains(err, "MAC mismatch")
+
+	// key sources
+	keyFile := filepath.Join(dir, "keys.txt")
+	req.NoError(os.WriteFile(keyFile, []byte(sopsTestAgeKey), 0o600))
+	t.Setenv("SOPS_AGE_KEY", "")
+	nodes, err = runSopsDecryptTransformer(t, "keySources: {ageKeyFile: "+keyFile+"}", encrypted)
+	req.NoError(err)
+	req.Contains(nodes[0].MustString(), "  password: secret\n")
+}
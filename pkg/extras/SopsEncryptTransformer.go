@@ -0,0 +1,418 @@
+package extras
+
+// cSpell: words shamir
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/cmd/sops/codes"
+	"github.com/getsops/sops/v3/cmd/sops/common"
+	"github.com/getsops/sops/v3/cmd/sops/formats"
+	"github.com/getsops/sops/v3/config"
+	"github.com/getsops/sops/v3/version"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/api/resource"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/resid"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/karmafun/karmafun/pkg/utils"
+)
+
+// SopsEncryptTransformerPlugin encrypts the resources selected by Targets,
+// all the Secrets by default, with sops.
+//
+// The encryption settings come from the creation rule of the sops
+// configuration file matching the path of the resource file. The
+// configuration file is ConfigPath if specified, or the .sops.yaml file found
+// in the directory of the resource file or its parents. The creation rule
+// should restrict the encrypted fields with encrypted_regex or
+// unencrypted_regex in order to keep the resource identity in clear text.
+//
+// The encrypted resources stay in place, i.e. they are written back to the
+// file they come from. If this file already contains the encrypted resource
+// and it can be decrypted, its data key is reused: the resource is left
+// unchanged if its content is the same, and only the modified values change
+// otherwise. When the previous version exists but its data key cannot be
+// reused, a result explains why. Resources that are already encrypted are
+// ignored.
+//
+// The data keys are encrypted and decrypted with the keys of KeySources, like
+// with the [SopsGeneratorPlugin].
+//
+// The resources decrypted by the [SopsDecryptTransformerPlugin] for
+// re-encryption are always encrypted, whatever the targets, and go back to
+// their file.
+type SopsEncryptTransformerPlugin struct {
+	h          *resmap.PluginHelpers
+	Targets    []*Selector     `json:"targets,omitempty"    yaml:"targets,omitempty"`
+	ConfigPath string          `json:"configPath,omitempty" yaml:"configPath,omitempty"`
+	KeySources *SopsKeySources `json:"keySources,omitempty" yaml:"keySources,omitempty"`
+	results    framework.Results
+}
+
+// Config configures the plugin.
+func (p *SopsEncryptTransformerPlugin) Config(h *resmap.PluginHelpers, c []byte) error {
+	if err := yaml.Unmarshal(c, p); err != nil {
+		return fmt.Errorf("while configuring SopsEncryptTransformerPlugin: %w", err)
+	}
+	if err := compileSelectors(p.Targets); err != nil {
+		return fmt.Errorf("while compiling SopsEncryptTransformerPlugin targets: %w", err)
+	}
+	if len(p.Targets) == 0 {
+		p.Targets = []*Selector{{Selector: types.Selector{ResId: resid.ResId{Gvk: resid.Gvk{Kind: "Secret"}}}}}
+	}
+	p.h = h
+	return nil
+}
+
+// selectResources returns the resources of m selected by the targets or
+// marked for re-encryption, in the order of m. Already encrypted resources are
+// not selected.
+func (p *SopsEncryptTransformerPlugin) selectResources(m resmap.ResMap) ([]*resource.Resource, error) {
+	selected := map[*resource.Resource]bool{}
+	for _, r := range m.Resources() {
+		if _, ok := r.GetAnnotations()[utils.FunctionAnnotationSopsReencrypt]; ok {
+			selected[r] = true
+		}
+	}
+	for _, t := range p.Targets {
+		resources, err := m.Select(t.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("while selecting target %s: %w", t.String(), err)
+		}
+		for _, r := range resources {
+			matches, err := t.matchesContent(&r.RNode)
+			if err != nil {
+				return nil, fmt.Errorf("while matching target %s: %w", t.String(), err)
+			}
+			if matches && !isSopsEncrypted(&r.RNode) {
+				selected[r] = true
+			}
+		}
+	}
+	return slices.DeleteFunc(m.Resources(), func(r *resource.Resource) bool { return !selected[r] }), nil
+}
+
+// Transform encrypts the selected resources of m. The resources are only
+// modified if all of them are encrypted.
+func (p *SopsEncryptTransformerPlugin) Transform(m resmap.ResMap) error {
+	p.results = nil
+	resources, err := p.selectResources(m)
+	if err != nil {
+		return err
+	}
+	if len(resources) == 0 {
+		return nil
+	}
+	keys, err := p.KeySources.keyRing(p.h.Loader().Root())
+	if err != nil {
+		return fmt.Errorf("while configuring key sources: %w", err)
+	}
+	defer keys.close()
+
+	encrypted := make([]*kyaml.RNode, len(resources))
+	var errs []error
+	for i, r := range resources {
+		if encrypted[i], err = p.encrypt(&r.RNode, keys); err != nil {
+			errs = append(errs, fmt.Errorf("while encrypting %s: %w", r.CurId(), err))
+		}
+	}
+	if len(errs) > 0 {
+		p.results = nil
+		return errors.Join(errs...)
+	}
+	for i, r := range resources {
+		r.SetYNode(encrypted[i].YNode())
+	}
+	return nil
+}
+
+// Results returns the resources encrypted by the last transformation.
+func (p *SopsEncryptTransformerPlugin) Results() framework.Results {
+	return p.results
+}
+
+// NewSopsEncryptTransformerPlugin returns a newly created [SopsEncryptTransformerPlugin].
+func NewSopsEncryptTransformerPlugin() resmap.TransformerPlugin {
+	return &SopsEncryptTransformerPlugin{}
+}
+
+// resourcePath returns the path of the file of n, as annotated by the
+// orchestrator, i.e. relative to the current directory, which is the root of
+// the loader. The path held by the config.karmafun.dev/path annotation, like
+// the one of a decrypted resource, takes precedence over the path given by
+// the orchestrator. Resources without path get the default path of the kio
+// writers.
+func resourcePath(n *kyaml.RNode) (string, error) {
+	path := n.GetAnnotations()[utils.FunctionAnnotationPath]
+	if path == "" {
+		var err error
+		if path, _, err = kioutil.GetFileAnnotations(n); err != nil {
+			return "", fmt.Errorf("while reading file annotations: %w", err)
+		}
+	}
+	if path == "" {
+		meta, err := n.GetMeta()
+		if err != nil {
+			return "", fmt.Errorf("while reading metadata: %w", err)
+		}
+		path = kioutil.CreatePathAnnotationValue("", meta)
+	}
+	return path, nil
+}
+
+// creationRule returns the sops creation rule matching the file at the
+// absolute path.
+func (p *SopsEncryptTransformerPlugin) creationRule(path string) (*config.Config, error) {
+	configPath := p.ConfigPath
+	if configPath == "" {
+		found, err := config.FindConfigFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("while looking for the sops configuration of %s: %w", path, err)
+		}
+		configPath = found
+	} else {
+		configPath = absolutePath(p.h.Loader().Root(), configPath)
+	}
+	rule, err := config.LoadCreationRuleForFile(configPath, path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("while loading the creation rule of %s from %s: %w", path, configPath, err)
+	}
+	if rule == nil {
+		return nil, fmt.Errorf("sops configuration %s has no creation rules", configPath)
+	}
+	return rule, nil
+}
+
+// encrypt returns the encrypted version of n. The data keys are encrypted
+// and decrypted with keys.
+func (p *SopsEncryptTransformerPlugin) encrypt(n *kyaml.RNode, keys *sopsKeyRing) (*kyaml.RNode, error) {
+	path, err := resourcePath(n)
+	if err != nil {
+		return nil, err
+	}
+	// the same absolute path selects the creation rule and the previous version
+	path = absolutePath(p.h.Loader().Root(), path)
+	rule, err := p.creationRule(path)
+	if err != nil {
+		return nil, err
+	}
+
+	plain := n.Copy()
+	transient, err := stripTransientAnnotations(plain)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := transient[utils.FunctionAnnotationSopsReencrypt]; ok {
+		// the decrypted resource goes back to its file
+		delete(transient, utils.FunctionAnnotationSopsReencrypt)
+		releaseFileAnnotations(transient)
+	}
+	text, err := plain.String()
+	if err != nil {
+		return nil, fmt.Errorf("while serializing resource: %w", err)
+	}
+	store := common.StoreForFormat(formats.Yaml, config.NewStoresConfig())
+	branches, err := store.LoadPlainFile([]byte(text))
+	if err != nil {
+		return nil, fmt.Errorf("while loading resource: %w", err)
+	}
+	tree := sops.Tree{
+		Branches: branches,
+		Metadata: sops.Metadata{
+			KeyGroups:               rule.KeyGroups,
+			ShamirThreshold:         rule.ShamirThreshold,
+			UnencryptedSuffix:       rule.UnencryptedSuffix,
+			EncryptedSuffix:         rule.EncryptedSuffix,
+			UnencryptedRegex:        rule.UnencryptedRegex,
+			EncryptedRegex:          rule.EncryptedRegex,
+			UnencryptedCommentRegex: rule.UnencryptedCommentRegex,
+			EncryptedCommentRegex:   rule.EncryptedCommentRegex,
+			MACOnlyEncrypted:        rule.MACOnlyEncrypted,
+			Version:                 version.Version,
+		},
+		FilePath: path,
+	}
+
+	var result *kyaml.RNode
+	var message string
+	cipher := aes.NewCipher()
+	previous, dataKey, err := p.previousVersion(n, path, &tree.Metadata, cipher, keys)
+	if err != nil {
+		severity := framework.Warning
+		if errors.Is(err, errEncryptionSettingsChanged) {
+			severity = framework.Info
+		}
+		p.results = append(p.results, &framework.Result{
+			Message:     fmt.Sprintf("data key of the previous version in %s not reused: %v", path, err),
+			Severity:    severity,
+			ResourceRef: resourceRef(n),
+			File:        resourceFile(n),
+		})
+	}
+	switch {
+	case previous != nil && samePlainText(store, previous.tree.Branches, branches):
+		result, message = previous.node, "unchanged, previous encryption kept"
+	case previous != nil:
+		tree.Metadata = previous.tree.Metadata
+		message = "encrypted with the data key of the previous version"
+	default:
+		var errs []error
+		if dataKey, errs = tree.GenerateDataKeyWithKeyServices(keys.clients()); len(errs) > 0 {
+			return nil, fmt.Errorf("while generating data key: %w", errors.Join(errs...))
+		}
+		message = "encrypted with a new data key"
+	}
+
+	if result == nil {
+		err = common.EncryptTree(common.EncryptTreeOpts{DataKey: dataKey, Tree: &tree, Cipher: cipher})
+		if err != nil {
+			return nil, fmt.Errorf("while encrypting resource: %w", err)
+		}
+		encrypted, err := store.EmitEncryptedFile(tree)
+		if err != nil {
+			return nil, fmt.Errorf("while serializing encrypted resource: %w", err)
+		}
+		if result, err = kyaml.Parse(string(encrypted)); err != nil {
+			return nil, fmt.Errorf("while reading encrypted resource: %w", err)
+		}
+		if !resid.FromRNode(result).Equals(resid.FromRNode(plain)) {
+			return nil, fmt.Errorf("the creation rule for %s encrypts the resource identity, "+
+				"restrict the encrypted fields with encrypted_regex", path)
+		}
+	}
+
+	if err = restoreAnnotations(result, transient); err != nil {
+		return nil, err
+	}
+	p.results = append(p.results, &framework.Result{
+		Message:     message,
+		Severity:    framework.Info,
+		ResourceRef: resourceRef(n),
+		File:        resourceFile(n),
+	})
+	return result, nil
+}
+
+// errEncryptionSettingsChanged tells that the previous version of a resource
+// is encrypted with other settings than the creation rule.
+var errEncryptionSettingsChanged = errors.New("encryption settings changed")
+
+// encryptedVersion is a decrypted sops encrypted resource.
+type encryptedVersion struct {
+	node *kyaml.RNode
+	tree sops.Tree
+}
+
+// previousVersion returns the encrypted version of n contained in the file
+// at path, if any, along with its data key. Without previous version, it
+// returns nil. An error tells why the previous version cannot be reused, i.e.
+// it cannot be read or decrypted with keys, or its encryption settings are
+// not the ones of metadata. cipher keeps the initialization vectors of the
+// decrypted values in order to produce the same encrypted values.
+func (p *SopsEncryptTransformerPlugin) previousVersion(
+	n *kyaml.RNode,
+	path string,
+	metadata *sops.Metadata,
+	cipher aes.Cipher,
+	keys *sopsKeyRing,
+) (*encryptedVersion, []byte, error) {
+	content, err := p.h.Loader().Load(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("while loading file: %w", err)
+	}
+	nodes, err := kio.FromBytes(content)
+	if err != nil {
+		return nil, nil, fmt.Errorf("while reading file: %w", err)
+	}
+	id := resid.FromRNode(n)
+	index := slices.IndexFunc(nodes, func(node *kyaml.RNode) bool {
+		return isSopsEncrypted(node) && resid.FromRNode(node).Equals(id)
+	})
+	if index < 0 {
+		return nil, nil, nil
+	}
+
+	node := nodes[index]
+	if _, err = stripTransientAnnotations(node); err != nil {
+		return nil, nil, err
+	}
+	text, err := node.String()
+	if err != nil {
+		return nil, nil, fmt.Errorf("while serializing previous version: %w", err)
+	}
+	store := common.StoreForFormat(formats.Yaml, config.NewStoresConfig())
+	tree, err := store.LoadEncryptedFile([]byte(text))
+	if err != nil {
+		return nil, nil, fmt.Errorf("while loading previous version: %w", err)
+	}
+	if !sameEncryptionSettings(&tree.Metadata, metadata) {
+		return nil, nil, errEncryptionSettingsChanged
+	}
+	dataKey, err := common.DecryptTree(common.DecryptTreeOpts{
+		KeyServices: keys.clients(),
+		Tree:        &tree,
+		Cipher:      cipher,
+	})
+	if err != nil {
+		var exitErr interface{ ExitCode() int }
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == codes.CouldNotRetrieveKey {
+			if keyErr := keys.dataKeyError(&tree.Metadata); keyErr != nil {
+				err = keyErr
+			}
+		}
+		return nil, nil, fmt.Errorf("previous version cannot be decrypted: %w", err)
+	}
+	return &encryptedVersion{node: node, tree: tree}, dataKey, nil
+}
+
+// samePlainText returns true if the branches a and b have the same content.
+func samePlainText(store common.Store, a, b sops.TreeBranches) bool {
+	left, err := store.EmitPlainFile(a)
+	if err != nil {
+		return false
+	}
+	right, err := store.EmitPlainFile(b)
+	return err == nil && bytes.Equal(left, right)
+}
+
+// sameEncryptionSettings returns true if the metadata a and b encrypt the
+// same fields with the same keys.
+func sameEncryptionSettings(a, b *sops.Metadata) bool {
+	return a.UnencryptedSuffix == b.UnencryptedSuffix &&
+		a.EncryptedSuffix == b.EncryptedSuffix &&
+		a.UnencryptedRegex == b.UnencryptedRegex &&
+		a.EncryptedRegex == b.EncryptedRegex &&
+		a.UnencryptedCommentRegex == b.UnencryptedCommentRegex &&
+		a.EncryptedCommentRegex == b.EncryptedCommentRegex &&
+		a.MACOnlyEncrypted == b.MACOnlyEncrypted &&
+		max(a.ShamirThreshold, 1) == max(b.ShamirThreshold, 1) &&
+		slices.EqualFunc(a.KeyGroups, b.KeyGroups, sameKeyGroup)
+}
+
+// sameKeyGroup returns true if the key groups a and b contain the same keys.
+func sameKeyGroup(a, b sops.KeyGroup) bool {
+	keys := func(group sops.KeyGroup) []string {
+		result := make([]string, 0, len(group))
+		for _, key := range group {
+			result = append(result, fmt.Sprintf("%T/%s", key, key.ToString()))
+		}
+		slices.Sort(result)
+		return result
+	}
+	return slices.Equal(keys(a), keys(b))
+}
@@ -0,0 +1,258 @@
+package extras_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/getsops/sops/v3/cmd/sops/formats"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/karmafun/karmafun/pkg/extras"
+)
+
+// cSpell: disable
+const (
+	sopsTestAgeKey = `# public key: age166k86d56ejs2ydvaxv2x3vl3wajny6l52dlkncf2k58vztnlecjs0g5jqq
+AGE-SECRET-KEY-15RKTPQCCLWM7EHQ8JEP0TQLUWJAECVP7332M3ZP0RL9R7JT7MZ6SY79V8Q
+`
+	sopsTestRecipient = "age166k86d56ejs2ydvaxv2x3vl3wajny6l52dlkncf2k58vztnlecjs0g5jqq"
+)
+
+// cSpell: enable
+
+const sopsTestConfig = `creation_rules:
+  - path_regex: clear/.*\.yaml$
+    age: ` + sopsTestRecipient + `
+  - path_regex: .*\.yaml$
+    encrypted_regex: ^(data|stringData)$
+    age: ` + sopsTestRecipient + `
+`
+
+// sopsTestDir returns a directory containing a sops configuration file.
+func sopsTestDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".sops.yaml"), []byte(sopsTestConfig), 0o600))
+	return dir
+}
+
+// sopsTestSecret returns a Secret saved in the file at path.
+func sopsTestSecret(path, password string) string {
+	return `apiVersion: v1
+kind: Secret
+metadata:
+  name: credentials
+  annotations:
+    ` + kioutil.PathAnnotation + `: ` + path + `
+type: Opaque
+stringData:
+  username: admin
+  password: ` + password + `
+`
+}
+
+// runSopsEncryptTransformer applies a sops encryption transformer configured
+// with config to input and returns the resulting resources along with the
+// results.
+func runSopsEncryptTransformer(t *testing.T, config, input string) ([]*yaml.RNode, framework.Results, error) {
+	t.Helper()
+	p := &extras.SopsEncryptTransformerPlugin{}
+	nodes, err := transformResources(t, p, config, input)
+	return nodes, p.Results(), err
+}
+
+// saveResource writes n to the file at path like the function orchestrator
+// and returns the written content.
+func saveResource(t *testing.T, n *yaml.RNode, path string) string {
+	t.Helper()
+	var b bytes.Buffer
+	err := kio.ByteWriter{
+		Writer:           &b,
+		ClearAnnotations: []string{kioutil.PathAnnotation, kioutil.LegacyPathAnnotation},
+	}.Write([]*yaml.RNode{n.Copy()})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, b.Bytes(), 0o600))
+	return b.String()
+}
+
+// encryptedLine returns the line of content containing the encrypted value
+// of key.
+func encryptedLine(t *testing.T, content, key string) string {
+	t.Helper()
+	line := regexp.MustCompile(`(?m)^\s*` + key + `: ENC\[.*$`).FindString(content)
+	require.NotEmpty(t, line, "value of %s should be encrypted", key)
+	return line
+}
+
+func TestSopsEncryptTransformer(t *testing.T) {
+	t.Setenv("SOPS_AGE_KEY", sopsTestAgeKey)
+	req := require.New(t)
+	dir := sopsTestDir(t)
+	path := filepath.Join(dir, "credentials.yaml")
+	configMap := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  user: admin
+`
+
+	// first encryption
+	nodes, results, err := runSopsEncryptTransformer(t, "", configMap+"---\n"+sopsTestSecret(path, "secret"))
+	req.NoError(err)
+	req.Len(results, 1, "a missing file has no previous version")
+	req.Equal("encrypted with a new data key", results[0].Message)
+	req.Len(nodes, 2)
+	req.Equal(configMap, nodes[0].MustString(), "ConfigMap should not be encrypted")
+	secret := nodes[1].MustString()
+	req.Contains(secret, "kind: Secret\n")
+	req.Equal(path, nodes[1].GetAnnotations()[kioutil.PathAnnotation], "resource should stay in place")
+	req.Contains(secret, "sops:\n")
+	req.NotContains(secret, "secret\n")
+	first := saveResource(t, nodes[1], path)
+	req.NotContains(first, kioutil.PathAnnotation)
+
+	decrypted, err := extras.Decrypt([]byte(first), formats.Yaml, path, false)
+	req.NoError(err, "encrypted file should decrypt with a valid MAC")
+	req.Contains(decrypted[0].MustString(), "  password: secret\n")
+
+	// same content
+	nodes, results, err = runSopsEncryptTransformer(t, "", sopsTestSecret(path, "secret"))
+	req.NoError(err)
+	req.Len(results, 1)
+	req.Equal("unchanged, previous encryption kept", results[0].Message)
+	req.Equal(first, saveResource(t, nodes[0], path), "unchanged resource should keep its encryption")
+
+	// modified content
+	nodes, _, err = runSopsEncryptTransformer(t, "targets: [{kind: Secret, name: credentials}]",
+		sopsTestSecret(path, "changed"))
+	req.NoError(err)
+	second := saveResource(t, nodes[0], path)
+	req.Equal(encryptedLine(t, first, "username"), encryptedLine(t, second, "username"),
+		"unchanged value should keep its encrypted value")
+	req.NotEqual(encryptedLine(t, first, "password"), encryptedLine(t, second, "password"))
+
+	decrypted, err = extras.Decrypt([]byte(second), formats.Yaml, path, false)
+	req.NoError(err)
+	req.Contains(decrypted[0].MustString(), "  password: changed\n")
+}
+
+func TestSopsEncryptTransformerErrors(t *testing.T) {
+	t.Parallel()
+	dir := sopsTestDir(t)
+	tests := []struct {
+		name    string
+		config  string
+		input   string
+		wantErr string
+	}{
+		{
+			name:    "no matching creation rule",
+			input:   sopsTestSecret(filepath.Join(dir, "credentials.txt"), "secret"),
+			wantErr: "no matching creation rules found",
+		},
+		{
+			name:    "resource identity encrypted",
+			input:   sopsTestSecret(filepath.Join(dir, "clear", "credentials.yaml"), "secret"),
+			wantErr: "encrypts the resource identity",
+		},
+		{
+			name:    "missing configuration",
+			config:  "configPath: " + filepath.Join(dir, "missing.yaml"),
+			input:   sopsTestSecret(filepath.Join(dir, "credentials.yaml"), "secret"),
+			wantErr: "while loading the creation rule",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, _, err := runSopsEncryptTransformer(t, tt.config, tt.input)
+			require.ErrorContains(t, err, "while encrypting Secret.v1.[noGrp]/credentials")
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestSopsEncryptTransformerPreviousVersion(t *testing.T) {
+	t.Setenv("SOPS_AGE_KEY", sopsTestAgeKey)
+	dir := sopsTestDir(t)
+	path := filepath.Join(dir, "credentials.yaml")
+	nodes, _, err := runSopsEncryptTransformer(t, "", sopsTestSecret(path, "secret"))
+	require.NoError(t, err)
+	saved := saveResource(t, nodes[0], path)
+
+	tests := []struct {
+		name         string
+		previous     string
+		environment  string
+		wantSeverity framework.Severity
+		wantMessage  string
+	}{
+		{
+			name:         "encryption settings changed",
+			previous:     strings.Replace(saved, "encrypted_regex: ^(data|stringData)$", "encrypted_regex: ^data$", 1),
+			environment:  sopsTestAgeKey,
+			wantSeverity: framework.Info,
+			wantMessage:  "not reused: encryption settings changed",
+		},
+		{
+			name:         "key not available",
+			previous:     saved,
+			wantSeverity: framework.Warning,
+			wantMessage:  "not reused: previous version cannot be decrypted: cannot decrypt the data key",
+		},
+		{
+			name:         "invalid previous version",
+			previous:     strings.Replace(saved, "mac: ", "mac: invalid", 1),
+			environment:  sopsTestAgeKey,
+			wantSeverity: framework.Warning,
+			wantMessage:  "not reused: previous version cannot be decrypted",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			t.Setenv("SOPS_AGE_KEY", tt.environment)
+			t.Setenv("SOPS_AGE_KEY_FILE", filepath.Join(dir, "missing.txt"))
+			require.NoError(t, os.WriteFile(path, []byte(tt.previous), 0o600))
+
+			_, results, err := runSopsEncryptTransformer(t, "", sopsTestSecret(path, "secret"))
+			req.NoError(err)
+			req.Len(results, 2)
+			req.Equal(tt.wantSeverity, results[0].Severity)
+			req.Contains(results[0].Message, tt.wantMessage)
+			req.Equal("encrypted with a new data key", results[1].Message)
+		})
+	}
+}
+
+func TestSopsEncryptTransformerKeySources(t *testing.T) {
+	t.Setenv("SOPS_AGE_KEY", "")
+	req := require.New(t)
+	dir := sopsTestDir(t)
+	path := filepath.Join(dir, "credentials.yaml")
+	keyFile := filepath.Join(dir, "keys.txt")
+	req.NoError(os.WriteFile(keyFile, []byte(sopsTestAgeKey), 0o600))
+	config := "keySources: {ageKeyFile: " + keyFile + "}"
+
+	nodes, _, err := runSopsEncryptTransformer(t, config, sopsTestSecret(path, "secret"))
+	req.NoError(err)
+	saved := saveResource(t, nodes[0], path)
+
+	nodes, results, err := runSopsEncryptTransformer(t, config, sopsTestSecret(path, "secret"))
+	req.NoError(err)
+	req.Len(results, 1)
+	req.Equal("unchanged, previous encryption kept", results[0].Message, "key file should decrypt the data key")
+	req.Equal(saved, saveResource(t, nodes[0], path))
+
+	_, _, err = runSopsEncryptTransformer(t, "keySources: {ageKeyFile: missing.txt}", sopsTestSecret(path, "secret"))
+	req.ErrorContains(err, "while configuring key sources")
+}
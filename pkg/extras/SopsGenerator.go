@@ -3,14 +3,17 @@ package extras
 // cSpell: words oyaml keyservice
 
 import (
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
 
+	"github.com/getsops/sops/v3"
 	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/cmd/sops/codes"
 	"github.com/getsops/sops/v3/cmd/sops/common"
 	"github.com/getsops/sops/v3/cmd/sops/formats"
 	"github.com/getsops/sops/v3/config"
-	"github.com/getsops/sops/v3/keyservice"
-	"sigs.k8s.io/kustomize/api/ifc"
 	"sigs.k8s.io/kustomize/api/resmap"
 	"sigs.k8s.io/kustomize/kyaml/kio"
 	yaml "sigs.k8s.io/kustomize/kyaml/yaml"
@@ -28,7 +31,14 @@ const (
 type SopsGeneratorPlugin struct {
 	yaml.ResourceMeta
 
-	Files []string `yaml:"files,omitempty"`
+	Files []SopsFile `json:"files,omitempty" yaml:"files,omitempty"`
+
+	KeySources *SopsKeySources `json:"keySources,omitempty" yaml:"keySources,omitempty"`
+
+	// Secrets are generated from the decrypted resources, that are then
+	// omitted. With an inline configuration, they are read from the decrypted
+	// configuration.
+	Secrets []SopsSecret `json:"secrets,omitempty" yaml:"secrets,omitempty"`
 
 	Sops map[string]any `json:"sops,omitempty" yaml:"spec,omitempty"`
 
@@ -37,24 +47,17 @@ type SopsGeneratorPlugin struct {
 }
 
 func Decrypt(b []byte, format formats.Format, file string, ignoreMac bool) ([]*yaml.RNode, error) {
-	store := common.StoreForFormat(format, config.NewStoresConfig())
-
-	// Load SOPS file and access the data key
-	tree, err := store.LoadEncryptedFile(b)
-	if err != nil {
-		return nil, fmt.Errorf("while loading encrypted file %s: %w", file, err)
-	}
+	return decryptResources(b, format, file, ignoreMac, defaultSopsKeyRing())
+}
 
-	_, err = common.DecryptTree(common.DecryptTreeOpts{
-		KeyServices: []keyservice.KeyServiceClient{
-			keyservice.NewLocalClient(),
-		},
-		Tree:      &tree,
-		IgnoreMac: ignoreMac,
-		Cipher:    aes.NewCipher(),
-	})
+// decryptResources decrypts the resources contained in the content b of file
+// with the keys of keys.
+func decryptResources(b []byte, format formats.Format, file string, ignoreMac bool,
+	keys *sopsKeyRing,
+) ([]*yaml.RNode, error) {
+	tree, store, err := decryptTree(b, format, file, ignoreMac, keys)
 	if err != nil {
-		return nil, fmt.Errorf("while decrypting tree for file %s: %w", file, err)
+		return nil, err
 	}
 
 	var data []byte
@@ -72,6 +75,38 @@ func Decrypt(b []byte, format formats.Format, file string, ignoreMac bool) ([]*y
 	return nodes, nil
 }
 
+// decryptTree decrypts the content b of file, in the given format, and
+// returns its decrypted tree along with the store of the format. When the
+// data key cannot be decrypted, the error details the failure of each key.
+func decryptTree(b []byte, format formats.Format, file string, ignoreMac bool,
+	keys *sopsKeyRing,
+) (*sops.Tree, common.Store, error) {
+	store := common.StoreForFormat(format, config.NewStoresConfig())
+
+	// Load SOPS file and access the data key
+	tree, err := store.LoadEncryptedFile(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("while loading encrypted file %s: %w", file, err)
+	}
+
+	_, err = common.DecryptTree(common.DecryptTreeOpts{
+		KeyServices: keys.clients(),
+		Tree:        &tree,
+		IgnoreMac:   ignoreMac,
+		Cipher:      aes.NewCipher(),
+	})
+	if err != nil {
+		var exitErr interface{ ExitCode() int }
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == codes.CouldNotRetrieveKey {
+			if keyErr := keys.dataKeyError(&tree.Metadata); keyErr != nil {
+				err = keyErr
+			}
+		}
+		return nil, nil, fmt.Errorf("while decrypting tree for file %s: %w", file, err)
+	}
+	return &tree, store, nil
+}
+
 // Config reads the function configuration, i.e. the kustomizeDirectory.
 func (p *SopsGeneratorPlugin) Config(h *resmap.PluginHelpers, c []byte) error {
 	err := oyaml.Unmarshal(c, p)
@@ -81,17 +116,36 @@ func (p *SopsGeneratorPlugin) Config(h *resmap.PluginHelpers, c []byte) error {
 	p.h = h
 	if p.Sops != nil {
 		p.buffer = c
+		if p.KeySources != nil {
+			// the key sources are not part of the encrypted configuration
+			config, err := yaml.Parse(string(c))
+			if err != nil {
+				return fmt.Errorf("while parsing configuration: %w", err)
+			}
+			if err = config.PipeE(yaml.Clear("keySources")); err != nil {
+				return fmt.Errorf("while removing key sources: %w", err)
+			}
+			p.buffer = []byte(config.MustString())
+		}
 	} else if p.Files == nil {
 		return fmt.Errorf("generator configuration doesn't contain any file")
 	}
 	return nil
 }
 
-func decryptBuffer(buffer []byte, name string, format formats.Format) ([]*yaml.RNode, error) {
+func decryptBuffer(buffer []byte, name string, format formats.Format, ignoreMac bool,
+	keys *sopsKeyRing,
+) ([]*yaml.RNode, error) {
 	if buffer == nil {
 		return nil, fmt.Errorf("buffer is nil for manifest %q", name)
 	}
-	nodes, err := Decrypt(buffer, format, name, true)
+	var nodes []*yaml.RNode
+	var err error
+	if ignoreMac {
+		nodes, err = decryptResources(buffer, format, name, true, keys)
+	} else {
+		nodes, err = decryptInlineConfig(buffer, format, name, keys)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error decoding manifest %q, content -->%s<--: %w", name, string(buffer), err)
 	}
@@ -103,45 +157,119 @@ func decryptBuffer(buffer []byte, name string, format formats.Format) ([]*yaml.R
 		if err := r.PipeE(yaml.SetAnnotation(utils.FunctionAnnotationInjectLocal, "true")); err != nil {
 			return nil, fmt.Errorf("while setting annotation on resource from file %s: %w", name, err)
 		}
+		if err := r.PipeE(yaml.ClearAnnotation(utils.FunctionAnnotationSopsIgnoreMac)); err != nil {
+			return nil, fmt.Errorf("while removing annotation on resource from file %s: %w", name, err)
+		}
 	}
 
 	return nodes, nil
 }
 
-func decryptFiles(files []string, loader ifc.Loader) ([]*yaml.RNode, error) {
-	var nodes []*yaml.RNode
-	for _, file := range files {
-		b, err := loader.Load(file)
+// decryptInlineConfig decrypts the inline encrypted function configuration
+// buffer and verifies its message authentication code.
+//
+// The configuration is not the document that was encrypted, as annotations
+// have been added to it by kustomize, the function orchestrator and the user
+// in order to make it a function. The original document is reconstructed by
+// removing these annotations and restoring the kind and API version given by
+// the config.karmafun.dev/kind and config.karmafun.dev/apiVersion annotations.
+// As the document may also have been encrypted after being turned into a
+// function, the configuration with only the orchestrator annotations removed
+// is tried next. The removed annotations are restored on the decrypted
+// resources.
+func decryptInlineConfig(buffer []byte, format formats.Format, name string, keys *sopsKeyRing) ([]*yaml.RNode, error) {
+	config, err := yaml.Parse(string(buffer))
+	if err != nil {
+		return nil, fmt.Errorf("while parsing configuration: %w", err)
+	}
+
+	original := config.Copy()
+	originalAnnotations, err := stripTransientAnnotations(original)
+	if err != nil {
+		return nil, err
+	}
+	if kind := originalAnnotations[utils.FunctionAnnotationKind]; kind != "" {
+		original.SetKind(kind)
+	}
+	if apiVersion := originalAnnotations[utils.FunctionAnnotationApiVersion]; apiVersion != "" {
+		original.SetApiVersion(apiVersion)
+	}
+	function := config.Copy()
+	functionAnnotations, err := stripAnnotations(function, isOrchestratorAnnotation)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, candidate := range []struct {
+		node        *yaml.RNode
+		annotations map[string]string
+	}{{original, originalAnnotations}, {function, functionAnnotations}} {
+		text, err := candidate.node.String()
 		if err != nil {
-			return nil, fmt.Errorf("while reading manifest %q: %w", file, err)
+			return nil, fmt.Errorf("while serializing configuration: %w", err)
 		}
-
-		format := formats.FormatForPath(file)
-		fileNodes, err := Decrypt(b, format, file, false)
+		nodes, err := decryptResources([]byte(text), format, name, false, keys)
 		if err != nil {
-			return nil, fmt.Errorf("while decrypting file %q: %w", file, err)
+			var exitErr interface{ ExitCode() int }
+			if !errors.As(err, &exitErr) || exitErr.ExitCode() != codes.MacMismatch {
+				return nil, err
+			}
+			errs = append(errs, err)
+			continue
+		}
+		for _, node := range nodes {
+			if err = restoreAnnotations(node, candidate.annotations); err != nil {
+				return nil, err
+			}
 		}
-		nodes = append(nodes, fileNodes...)
+		return nodes, nil
 	}
-	return nodes, nil
+	return nil, fmt.Errorf("configuration doesn't match its message authentication code, "+
+		"set the %s annotation to skip the verification: %w", utils.FunctionAnnotationSopsIgnoreMac, errors.Join(errs...))
 }
 
 // Generate generates the resources of the directory.
 func (p *SopsGeneratorPlugin) Generate() (resmap.ResMap, error) {
-	var nodes []*yaml.RNode
-	var err error
+	keys, err := p.KeySources.keyRing(p.h.Loader().Root())
+	if err != nil {
+		return nil, fmt.Errorf("while configuring key sources: %w", err)
+	}
+	defer keys.close()
+
+	var documents, nodes []*yaml.RNode
+	secrets := p.Secrets
 	if p.buffer != nil {
-		nodes, err = decryptBuffer(p.buffer, p.GetIdentifier().Name, formats.Yaml)
+		ignoreMac, _ := strconv.ParseBool(p.Annotations[utils.FunctionAnnotationSopsIgnoreMac])
+		documents, err = decryptBuffer(p.buffer, p.GetIdentifier().Name, formats.Yaml, ignoreMac, keys)
 		if err != nil {
 			return nil, fmt.Errorf("error decrypting buffer: %w", err)
 		}
+		// the secrets of the configuration may be encrypted
+		secrets = nil
+		for _, document := range documents {
+			documentSecrets, err := takeSopsSecrets(document)
+			if err != nil {
+				return nil, err
+			}
+			secrets = append(secrets, documentSecrets...)
+		}
 	} else {
-		nodes, err = decryptFiles(p.Files, p.h.Loader())
+		documents, nodes, err = decryptSopsFiles(p.Files, p.GetIdentifier().Name, p.h.Loader(), keys)
 		if err != nil {
 			return nil, fmt.Errorf("error decrypting files: %w", err)
 		}
 	}
-	return utils.ResourceMapFromNodes(nodes), nil
+
+	if len(secrets) > 0 {
+		generated, sources, err := generateSopsSecrets(secrets, documents)
+		if err != nil {
+			return nil, fmt.Errorf("error generating secrets: %w", err)
+		}
+		documents = slices.DeleteFunc(documents, func(document *yaml.RNode) bool { return sources[document] })
+		nodes = append(nodes, generated...)
+	}
+	return utils.ResourceMapFromNodes(append(documents, nodes...)), nil
 }
 
 // NewSopsGeneratorPlugin returns a newly Created SopsGenerator.
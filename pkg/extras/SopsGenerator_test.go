@@ -0,0 +1,456 @@
+package extras_test
+
+import (
+	"encoding/base64"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/cmd/sops/common"
+	"github.com/getsops/sops/v3/cmd/sops/formats"
+	"github.com/getsops/sops/v3/config"
+	"github.com/getsops/sops/v3/keyservice"
+	"github.com/getsops/sops/v3/version"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"sigs.k8s.io/kustomize/api/resmap"
+
+	"github.com/karmafun/karmafun/pkg/extras"
+	"github.com/karmafun/karmafun/pkg/plugins"
+	"github.com/karmafun/karmafun/pkg/utils"
+)
+
+// sopsInlineGenerator returns an inline SopsGenerator configuration made from
+// the encrypted Secret in encrypted, with the given additional annotations.
+func sopsInlineGenerator(encrypted string, annotations ...string) string {
+	header := `apiVersion: karmafun.dev/v1alpha1
+kind: SopsGenerator
+metadata:
+  name: credentials
+  annotations:
+    config.karmafun.dev/kind: Secret
+    config.karmafun.dev/apiVersion: v1
+    config.kubernetes.io/function: |
+      exec:
+        path: karmafun
+`
+	for _, annotation := range annotations {
+		header += "    " + annotation + "\n"
+	}
+	return strings.Replace(encrypted, "apiVersion: v1\nkind: Secret\nmetadata:\n  name: credentials\n", header, 1)
+}
+
+func TestSopsGeneratorInlineMac(t *testing.T) {
+	t.Setenv("SOPS_AGE_KEY", sopsTestAgeKey)
+	dir := sopsTestDir(t)
+	path := filepath.Join(dir, "credentials.yaml")
+	nodes, _, err := runSopsEncryptTransformer(t, "", sopsTestSecret(path, "secret"))
+	require.NoError(t, err)
+	encrypted := saveResource(t, nodes[0], path)
+	tampered := strings.Replace(encrypted, "type: Opaque", "type: Tampered", 1)
+
+	tests := []struct {
+		name    string
+		config  string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "verified",
+			config: sopsInlineGenerator(encrypted),
+			want:   "  password: secret\n",
+		},
+		{
+			name:    "tampered",
+			config:  sopsInlineGenerator(tampered),
+			wantErr: "configuration doesn't match its message authentication code",
+		},
+		{
+			name:   "tampered with ignored MAC",
+			config: sopsInlineGenerator(tampered, utils.FunctionAnnotationSopsIgnoreMac+`: "true"`),
+			want:   "type: Tampered\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			helpers, err := plugins.NewPluginHelpers()
+			req.NoError(err)
+			p := extras.NewSopsGeneratorPlugin()
+			req.NoError(p.Config(helpers, []byte(tt.config)))
+
+			rm, err := p.Generate()
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			req.Equal(1, rm.Size())
+			generated := rm.Resources()[0]
+			req.Contains(generated.MustString(), tt.want)
+			req.Equal("PlatformSecrets", generated.GetKind())
+			req.NotContains(generated.GetAnnotations(), utils.FunctionAnnotationSopsIgnoreMac)
+			req.Equal("Secret", generated.GetAnnotations()[utils.FunctionAnnotationKind],
+				"configuration annotations should be kept")
+		})
+	}
+}
+
+// writeSopsFile encrypts plain in the given format for the test recipient and
+// writes it to the file name of dir. It returns the path of the file.
+func writeSopsFile(t *testing.T, dir, name string, format formats.Format, plain string) string {
+	t.Helper()
+	req := require.New(t)
+	store := common.StoreForFormat(format, config.NewStoresConfig())
+	branches, err := store.LoadPlainFile([]byte(plain))
+	req.NoError(err)
+	keys, err := age.MasterKeysFromRecipients(sopsTestRecipient)
+	req.NoError(err)
+	tree := sops.Tree{
+		Branches: branches,
+		Metadata: sops.Metadata{KeyGroups: []sops.KeyGroup{{keys[0]}}, Version: version.Version},
+	}
+	dataKey, errs := tree.GenerateDataKeyWithKeyServices([]keyservice.KeyServiceClient{keyservice.NewLocalClient()})
+	req.Empty(errs)
+	req.NoError(common.EncryptTree(common.EncryptTreeOpts{DataKey: dataKey, Tree: &tree, Cipher: aes.NewCipher()}))
+	encrypted, err := store.EmitEncryptedFile(tree)
+	req.NoError(err)
+	path := filepath.Join(dir, name)
+	req.NoError(os.WriteFile(path, encrypted, 0o600))
+	return path
+}
+
+// generatedData returns the decoded data of the resources of rm by
+// kind/namespace/name.
+func generatedData(t *testing.T, rm resmap.ResMap) map[string]map[string]string {
+	t.Helper()
+	got := map[string]map[string]string{}
+	for _, r := range rm.Resources() {
+		name := r.GetKind() + "/" + r.GetName()
+		if r.GetNamespace() != "" {
+			name = r.GetKind() + "/" + r.GetNamespace() + "/" + r.GetName()
+		}
+		data := map[string]string{}
+		for key, value := range r.GetDataMap() {
+			if r.GetKind() == "Secret" {
+				decoded, err := base64.StdEncoding.DecodeString(value)
+				require.NoError(t, err)
+				value = string(decoded)
+			}
+			data[key] = value
+		}
+		got[name] = data
+	}
+	return got
+}
+
+func TestSopsGeneratorFiles(t *testing.T) {
+	t.Setenv("SOPS_AGE_KEY", sopsTestAgeKey)
+	dir := t.TempDir()
+	env := writeSopsFile(t, dir, "app.env", formats.Dotenv, "USER=admin\nPASSWORD=secret\n")
+	ini := writeSopsFile(t, dir, "settings.ini", formats.Ini, "timeout = 5\n\n[database]\npassword = db\n")
+	json := writeSopsFile(t, dir, "config.json", formats.Json, `{"api": {"token": "abc"}, "debug": true}`)
+	numbers := writeSopsFile(t, dir, "numbers.json", formats.Json, `{"maxConnections": 1000000, "ratio": 0.00001}`)
+	list := writeSopsFile(t, dir, "list.json", formats.Json, `{"hosts": ["a", "b"]}`)
+	binary := writeSopsFile(t, dir, "keystore.p12", formats.Binary, "\xff\x00binary")
+
+	tests := []struct {
+		name    string
+		config  string
+		want    map[string]map[string]string
+		wantErr string
+	}{
+		{
+			name: "secret entries",
+			config: `
+files:
+  - ` + env + `
+  - {path: ` + ini + `, keyPrefix: ini.}
+  - {path: ` + json + `, name: app-secrets}
+  - ` + binary + `
+`,
+			want: map[string]map[string]string{
+				"Secret/app-secrets": {
+					"USER":                  "admin",
+					"PASSWORD":              "secret",
+					"ini.timeout":           "5",
+					"ini.database.password": "db",
+					"api.token":             "abc",
+					"debug":                 "true",
+					"keystore.p12":          "\xff\x00binary",
+				},
+			},
+		},
+		{
+			name: "configmap with whole file",
+			config: `
+files:
+  - {path: ` + json + `, kind: ConfigMap, name: settings, key: config.json}
+  - {path: ` + env + `, name: credentials, namespace: apps, type: app/env}
+`,
+			want: map[string]map[string]string{
+				"ConfigMap/settings":      {"config.json": "{\n\t\"api\": {\n\t\t\"token\": \"abc\"\n\t},\n\t\"debug\": true\n}\n"},
+				"Secret/apps/credentials": {"USER": "admin", "PASSWORD": "secret"},
+			},
+		},
+		{
+			name:   "json numbers",
+			config: "files: [{path: " + numbers + ", name: limits}]",
+			want: map[string]map[string]string{
+				"Secret/limits": {"maxConnections": "1000000", "ratio": "0.00001"},
+			},
+		},
+		{
+			name:    "list entry",
+			config:  "files: [{path: " + list + ", name: hosts}]",
+			wantErr: "entry hosts is a list, use key to store the whole file",
+		},
+		{
+			name:    "data without resource settings",
+			config:  "files: [" + json + "]",
+			wantErr: "doesn't contain resources",
+		},
+		{
+			name:    "invalid format",
+			config:  "files: [{path: " + env + ", format: toml}]",
+			wantErr: `invalid format "toml"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			helpers, err := plugins.NewPluginHelpers()
+			req.NoError(err)
+			p := extras.NewSopsGeneratorPlugin()
+			req.NoError(p.Config(helpers, []byte("metadata: {name: app-secrets}\n"+tt.config)))
+
+			rm, err := p.Generate()
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			req.Equal(tt.want, generatedData(t, rm))
+		})
+	}
+}
+
+// sopsOtherAgeKey is an age identity the test files are not encrypted for.
+const sopsOtherAgeKey = "AGE-SECRET-KEY-10VVTC02NN3940H7KEH2R2RU45FAFAF7VFSDEYQAA5Q88XL6TLPLSTGLEHZ"
+
+// serveSopsKeyService starts a sops key service using the keys of the
+// environment on a unix socket of dir and returns its address.
+func serveSopsKeyService(t *testing.T, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, "keyservice.sock")
+	listener, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	keyservice.RegisterKeyServiceServer(server, keyservice.Server{})
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+	return "unix://" + path
+}
+
+func TestSopsGeneratorKeySources(t *testing.T) {
+	dir := t.TempDir()
+	env := writeSopsFile(t, dir, "app.env", formats.Dotenv, "PASSWORD=secret\n")
+	keyFile := filepath.Join(dir, "keys.txt")
+	require.NoError(t, os.WriteFile(keyFile, []byte(sopsTestAgeKey), 0o600))
+	otherKeyFile := filepath.Join(dir, "other.txt")
+	require.NoError(t, os.WriteFile(otherKeyFile, []byte(sopsOtherAgeKey), 0o600))
+	remote := serveSopsKeyService(t, dir)
+	missing := "unix://" + filepath.Join(dir, "missing.sock")
+	files := "metadata: {name: credentials}\nfiles: [" + env + "]\n"
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	relativeKeyFile, err := filepath.Rel(wd, keyFile)
+	require.NoError(t, err)
+
+	path := filepath.Join(sopsTestDir(t), "credentials.yaml")
+	nodes, _, err := runSopsEncryptTransformer(t, "", sopsTestSecret(path, "secret"))
+	require.NoError(t, err)
+	inline := sopsInlineGenerator(saveResource(t, nodes[0], path)) + "keySources:\n  ageKeyFile: " + keyFile + "\n"
+
+	tests := []struct {
+		name       string
+		environKey string
+		config     string
+		wantErr    []string
+	}{
+		{
+			name:       "environment",
+			environKey: sopsTestAgeKey,
+			config:     files,
+		},
+		{
+			name:       "age key file",
+			environKey: sopsOtherAgeKey,
+			config:     files + "keySources: {ageKeyFile: " + keyFile + "}",
+		},
+		{
+			name:       "age key file relative to the loader root",
+			environKey: sopsOtherAgeKey,
+			config:     files + "keySources: {ageKeyFile: " + relativeKeyFile + "}",
+		},
+		{
+			name:       "remote key service",
+			environKey: sopsTestAgeKey,
+			config:     files + "keySources: {ageKeyFile: " + otherKeyFile + ", keyServices: [" + remote + "]}",
+		},
+		{
+			name:       "inline configuration",
+			environKey: sopsOtherAgeKey,
+			config:     inline,
+		},
+		{
+			name:       "no key",
+			environKey: sopsTestAgeKey,
+			config:     files + "keySources: {ageKeyFile: " + otherKeyFile + ", keyServices: [" + missing + "]}",
+			wantErr: []string{
+				"1 key group(s) required, 0 out of 1 decrypted",
+				"key group 1, age key " + sopsTestRecipient + " with local key service",
+				"key group 1, age key " + sopsTestRecipient + " with " + missing,
+			},
+		},
+		{
+			name:    "invalid key service address",
+			config:  files + "keySources: {keyServices: [http://localhost]}",
+			wantErr: []string{"invalid key service address http://localhost"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SOPS_AGE_KEY", tt.environKey)
+			req := require.New(t)
+			helpers, err := plugins.NewPluginHelpers()
+			req.NoError(err)
+			p := extras.NewSopsGeneratorPlugin()
+			req.NoError(p.Config(helpers, []byte(tt.config)))
+
+			rm, err := p.Generate()
+			if len(tt.wantErr) > 0 {
+				for _, want := range tt.wantErr {
+					req.ErrorContains(err, want)
+				}
+				return
+			}
+			req.NoError(err)
+			req.Equal(1, rm.Size())
+			req.NotContains(rm.Resources()[0].MustString(), "keySources")
+		})
+	}
+}
+
+func TestSopsGeneratorSecrets(t *testing.T) {
+	t.Setenv("SOPS_AGE_KEY", sopsTestAgeKey)
+	dir := t.TempDir()
+	platform := `apiVersion: config.karmafun.dev/v1alpha1
+kind: PlatformSecrets
+metadata:
+  name: platform
+data:
+  github:
+    ssh_key: |
+      private key
+    password: secret
+  registry:
+    auths:
+      ghcr.io:
+        auth: dXNlcjpzZWNyZXQ=
+  tls:
+    crt: certificate
+    key: private key
+    ca.crt: authority
+`
+	file := writeSopsFile(t, dir, "platform.yaml", formats.Yaml, platform)
+	inline, err := os.ReadFile(writeSopsFile(t, dir, "inline.yaml", formats.Yaml,
+		platform+"secrets:\n  - {name: github, fieldPath: data.github}\n"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		config  string
+		want    map[string]map[string]string
+		types   map[string]string
+		wantErr string
+	}{
+		{
+			name: "files",
+			config: `metadata: {name: platform}
+files: [` + file + `]
+secrets:
+  - name: github
+    fieldPath: data.github
+  - name: registry
+    namespace: apps
+    type: kubernetes.io/dockerconfigjson
+    keys: {.dockerconfigjson: registry}
+  - name: tls
+    type: kubernetes.io/tls
+    fieldPath: data.tls
+    keys: {tls.crt: crt, tls.key: key, ca.crt: "[ca.crt]"}
+`,
+			want: map[string]map[string]string{
+				"Secret/github":        {"ssh_key": "private key\n", "password": "secret"},
+				"Secret/apps/registry": {".dockerconfigjson": `{"auths":{"ghcr.io":{"auth":"dXNlcjpzZWNyZXQ="}}}`},
+				"Secret/tls":           {"tls.crt": "certificate", "tls.key": "private key", "ca.crt": "authority"},
+			},
+			types: map[string]string{
+				"github":   "Opaque",
+				"registry": "kubernetes.io/dockerconfigjson",
+				"tls":      "kubernetes.io/tls",
+			},
+		},
+		{
+			name:   "encrypted inline configuration",
+			config: string(inline),
+			want: map[string]map[string]string{
+				"Secret/github": {"ssh_key": "private key\n", "password": "secret"},
+			},
+			types: map[string]string{"github": "Opaque"},
+		},
+		{
+			name:    "missing field",
+			config:  "files: [" + file + "]\nsecrets: [{name: github, fieldPath: data.gitlab}]",
+			wantErr: "field data.gitlab of Secret github not found",
+		},
+		{
+			name:    "missing key",
+			config:  "files: [" + file + "]\nsecrets: [{name: tls, fieldPath: data.tls, keys: {tls.crt: cert}}]",
+			wantErr: "field cert of key tls.crt not found",
+		},
+		{
+			name:    "not a map",
+			config:  "files: [" + file + "]\nsecrets: [{name: github, fieldPath: data.github.password}]",
+			wantErr: "field data.github.password is not a map",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			helpers, err := plugins.NewPluginHelpers()
+			req.NoError(err)
+			p := extras.NewSopsGeneratorPlugin()
+			req.NoError(p.Config(helpers, []byte(tt.config)))
+
+			rm, err := p.Generate()
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			req.Equal(tt.want, generatedData(t, rm))
+			for _, r := range rm.Resources() {
+				secretType, err := r.GetString("type")
+				req.NoError(err)
+				req.Equal(tt.types[r.GetName()], secretType, "type of Secret %s", r.GetName())
+			}
+		})
+	}
+}
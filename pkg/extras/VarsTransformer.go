@@ -0,0 +1,335 @@
+package extras
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/resid"
+	kyaml_utils "sigs.k8s.io/kustomize/kyaml/utils"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// varReferenceRegexp matches the variable references. The first group is
+// the escaping $, the next three groups are the kind, the namespace and name
+// and the field path of a resource reference, and the last two groups are the
+// alias and the field path of an alias reference.
+var varReferenceRegexp = regexp.MustCompile(
+	`(\$?)\$(?:\(([A-Za-z][A-Za-z0-9]*)/([^:()\s]+):([^()\s]+)\)|\{([A-Za-z_][\w-]*):([^{}\s]+)\})`)
+
+// varAliasRegexp matches the valid alias names.
+var varAliasRegexp = regexp.MustCompile(`^[A-Za-z_][\w-]*$`)
+
+// maxVarDepth is the maximum nesting of references in resolved values.
+const maxVarDepth = 10
+
+// VarsTransformerPlugin replaces the variable references found in the string
+// fields of the resources, including the text of embedded documents like Helm
+// values, by the value of the referenced field.
+//
+// A reference designates a resource by its kind, optional namespace and name,
+// followed by the path of the field:
+//
+//	$(ConfigMap/configuration-map:data.repoURL)
+//	$(Application/argocd/app:spec.source.helm.values.!!yaml.common.targetRevision)
+//
+// or uses an alias defined in Vars, the field path being relative to the
+// field path of the alias:
+//
+//	${values:traefik.expose}
+//
+// The field path can contain extended segments. Resolved values containing
+// references are resolved in turn, up to a depth of 10. In embedded YAML
+// documents, the references are resolved in each scalar, which is quoted as
+// needed. A reference is escaped by doubling its $:
+// $$(ConfigMap/name:data.key) is replaced by $(ConfigMap/name:data.key).
+// ${name:default} texts whose name is not an alias are left unchanged.
+//
+// The referenced resources are selected in the transformed resources, or in
+// the resources of Source if specified. The transformation fails without
+// modifying the resources if a reference cannot be resolved.
+type VarsTransformerPlugin struct {
+	h       *resmap.PluginHelpers
+	Source  SourcePaths                `json:"source,omitempty" yaml:"source,omitempty"`
+	Vars    map[string]*SourceSelector `json:"vars,omitempty"   yaml:"vars,omitempty"`
+	results framework.Results
+}
+
+// Config configures the plugin.
+func (p *VarsTransformerPlugin) Config(h *resmap.PluginHelpers, c []byte) error {
+	if err := yaml.Unmarshal(c, p); err != nil {
+		return fmt.Errorf("while configuring VarsTransformerPlugin: %w", err)
+	}
+	for alias := range p.Vars {
+		if !varAliasRegexp.MatchString(alias) {
+			return fmt.Errorf("invalid variable alias %q", alias)
+		}
+	}
+	p.h = h
+	return nil
+}
+
+// Transform replaces the variable references in the resources of m.
+func (p *VarsTransformerPlugin) Transform(m resmap.ResMap) error {
+	p.results = nil
+	resolver := &varResolver{nodes: m.ToRNodeSlice(), vars: p.Vars}
+	if len(p.Source) > 0 {
+		sources, err := loadSourceLayers(p.h, p.Source)
+		if err != nil {
+			return fmt.Errorf("while loading source from path %s: %w", strings.Join(p.Source, ", "), err)
+		}
+		resolver.nodes = sources.nodes
+	}
+
+	// the fields are only modified when all the references are resolved
+	type substitution struct {
+		node  *yaml.Node
+		value string
+	}
+	var substitutions []substitution
+	var errs []error
+	for _, r := range m.Resources() {
+		walkScalars(r.YNode(), nil, func(node *yaml.Node, path []string) {
+			if !strings.Contains(node.Value, "$") {
+				return
+			}
+			value, references, err := resolver.expandField(node.Value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("in field %s of %s: %w", strings.Join(path, "."), r.CurId(), err))
+				return
+			}
+			if value == node.Value {
+				return
+			}
+			substitutions = append(substitutions, substitution{node: node, value: value})
+			if len(references) > 0 {
+				p.results = append(p.results, &framework.Result{
+					Message:     fmt.Sprintf("field %s resolved %s", strings.Join(path, "."), strings.Join(references, ", ")),
+					Severity:    framework.Info,
+					ResourceRef: resourceRef(&r.RNode),
+					Field:       &framework.Field{Path: strings.Join(path, ".")},
+					File:        resourceFile(&r.RNode),
+				})
+			}
+		})
+	}
+	if len(errs) > 0 {
+		p.results = nil
+		return fmt.Errorf("while resolving variables: %w", errors.Join(errs...))
+	}
+	for _, s := range substitutions {
+		setVarValue(s.node, s.value)
+	}
+	return nil
+}
+
+// setVarValue sets the value of the scalar node to the resolved value.
+func setVarValue(node *yaml.Node, value string) {
+	whole := varReferenceRegexp.FindString(node.Value) == node.Value
+	node.Value = value
+	if whole && node.Style == 0 {
+		// a field containing only a reference takes the type of its value
+		node.Tag = ""
+	}
+}
+
+// Results returns the fields modified by the last transformation.
+func (p *VarsTransformerPlugin) Results() framework.Results {
+	return p.results
+}
+
+// NewVarsTransformerPlugin returns a newly created [VarsTransformerPlugin].
+func NewVarsTransformerPlugin() resmap.TransformerPlugin {
+	return &VarsTransformerPlugin{}
+}
+
+// walkScalars calls fn on the scalar values of node and its descendants with
+// their path. Mapping keys are not visited.
+func walkScalars(node *yaml.Node, path []string, fn func(node *yaml.Node, path []string)) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			walkScalars(child, path, fn)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			walkScalars(node.Content[i+1], slices.Concat(path, []string{node.Content[i].Value}), fn)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			walkScalars(item, slices.Concat(path, []string{strconv.Itoa(i)}), fn)
+		}
+	case yaml.ScalarNode:
+		fn(node, path)
+	}
+}
+
+// varResolver resolves the variable references in the resources of nodes.
+type varResolver struct {
+	nodes []*yaml.RNode
+	vars  map[string]*SourceSelector
+}
+
+// expandField returns the value of a field with its references replaced by
+// their value and the list of the resolved references. When the field
+// contains an embedded YAML document, the references are replaced in the
+// scalars of the document, so that the values are quoted as needed.
+func (r *varResolver) expandField(text string) (string, []string, error) {
+	extender := &yamlExtender{}
+	if !strings.Contains(text, "\n") || extender.SetPayload([]byte(text)) != nil ||
+		extender.node.YNode().Kind != yaml.MappingNode {
+		return r.expand(text, nil)
+	}
+	var references []string
+	var errs []error
+	modified := false
+	walkScalars(extender.node.YNode(), nil, func(node *yaml.Node, _ []string) {
+		if !strings.Contains(node.Value, "$") {
+			return
+		}
+		value, nodeReferences, err := r.expand(node.Value, nil)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if value != node.Value {
+			setVarValue(node, value)
+			modified = true
+		}
+		references = append(references, nodeReferences...)
+	})
+	if len(errs) > 0 || !modified {
+		return text, references, errors.Join(errs...)
+	}
+	payload, err := extender.GetPayload()
+	if err != nil {
+		return "", nil, fmt.Errorf("while serializing embedded YAML: %w", err)
+	}
+	return string(payload), references, nil
+}
+
+// expand returns text with its references replaced by their value and the
+// list of the resolved references. stack contains the references being
+// resolved, in order to detect cycles.
+func (r *varResolver) expand(text string, stack []string) (string, []string, error) {
+	var b strings.Builder
+	var references []string
+	var errs []error
+	last := 0
+	for _, match := range varReferenceRegexp.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(text[last:match[0]])
+		last = match[1]
+		reference := text[match[0]:match[1]]
+		group := func(i int) string {
+			if match[2*i] < 0 {
+				return ""
+			}
+			return text[match[2*i]:match[2*i+1]]
+		}
+		alias := group(5)
+		if alias != "" && r.vars[alias] == nil {
+			// not a reference, like a ${name:default} placeholder
+			b.WriteString(reference)
+			continue
+		}
+		if group(1) != "" {
+			// escaped reference
+			b.WriteString(reference[1:])
+			continue
+		}
+		if slices.Contains(stack, reference) {
+			errs = append(errs, fmt.Errorf("reference %s is circular: %s", reference,
+				strings.Join(slices.Concat(stack, []string{reference}), " -> ")))
+			b.WriteString(reference)
+			continue
+		}
+		if len(stack) >= maxVarDepth {
+			errs = append(errs, fmt.Errorf("reference %s exceeds the maximum depth of %d: %s", reference, maxVarDepth,
+				strings.Join(slices.Concat(stack, []string{reference}), " -> ")))
+			b.WriteString(reference)
+			continue
+		}
+
+		var value string
+		var err error
+		if alias != "" {
+			value, err = r.lookupAlias(alias, group(6))
+		} else {
+			value, err = r.lookup(group(2), group(3), group(4))
+		}
+		if err == nil {
+			value, _, err = r.expand(value, slices.Concat(stack, []string{reference}))
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unresolved reference %s: %w", reference, err))
+			b.WriteString(reference)
+			continue
+		}
+		b.WriteString(value)
+		references = append(references, reference)
+	}
+	b.WriteString(text[last:])
+	return b.String(), references, errors.Join(errs...)
+}
+
+// lookupAlias returns the value of the field at path relative to the field
+// path of alias.
+func (r *varResolver) lookupAlias(alias, path string) (string, error) {
+	selector := *r.vars[alias]
+	if selector.FieldPath != "" {
+		path = selector.FieldPath + "." + path
+	}
+	selector.FieldPath = path
+	return r.value(&selector)
+}
+
+// lookup returns the value of the field at path in the resource of kind
+// designated by name, optionally prefixed by its namespace.
+func (r *varResolver) lookup(kind, name, path string) (string, error) {
+	namespace, name, found := strings.Cut(name, "/")
+	if !found {
+		namespace, name = "", namespace
+	}
+	return r.value(&SourceSelector{SourceSelector: types.SourceSelector{
+		ResId:     resid.ResId{Gvk: resid.Gvk{Kind: kind}, Name: name, Namespace: namespace},
+		FieldPath: path,
+	}})
+}
+
+// value returns the value of the field selected by selector. The field path
+// can contain extended segments.
+func (r *varResolver) value(selector *SourceSelector) (string, error) {
+	source, err := selectSourceNode(r.nodes, nil, selector)
+	if err != nil {
+		return "", err
+	}
+	extendedPath, err := NewExtendedPath(kyaml_utils.SmarterPathSplitter(selector.FieldPath, "."))
+	if err != nil {
+		return "", err
+	}
+	decode := isBase64Field(source, extendedPath.ResourcePath)
+	if decode {
+		extendedPath.DecodeBase64()
+	}
+	field, err := source.Pipe(yaml.Lookup(extendedPath.ResourcePath...))
+	if err != nil {
+		return "", fmt.Errorf("while looking up %s: %w", selector.FieldPath, err)
+	}
+	if field == nil {
+		return "", fmt.Errorf("field %s not found in %s", selector.FieldPath, resid.FromRNode(source))
+	}
+	if field.YNode().Kind != yaml.ScalarNode {
+		return "", fmt.Errorf("field %s of %s is not a scalar", selector.FieldPath, resid.FromRNode(source))
+	}
+	value, err := readField(field, extendedPath, decode)
+	if err != nil {
+		return "", fmt.Errorf("while reading %s: %w", selector.FieldPath, err)
+	}
+	return value.YNode().Value, nil
+}
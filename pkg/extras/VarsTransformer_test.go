@@ -0,0 +1,199 @@
+package extras_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+
+	"github.com/karmafun/karmafun/pkg/extras"
+	"github.com/karmafun/karmafun/pkg/plugins"
+	"github.com/karmafun/karmafun/pkg/utils"
+)
+
+const varsResources = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: configuration-map
+data:
+  repoURL: https://github.com/karmafun/karmafun.git
+  targetRevision: deploy/citest
+  replicas: "3"
+  note: "warning: keep the quotes"
+  values: |
+    traefik:
+      expose: true
+---
+apiVersion: config.karmafun.dev/v1alpha1
+kind: LocalConfiguration
+metadata:
+  name: values
+  namespace: argocd
+data:
+  traefik:
+    expose: $(ConfigMap/configuration-map:data.values.!!yaml.traefik.expose)
+`
+
+func TestVarsTransformer(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		config   string
+		input    string
+		want     []string
+		wantErr  []string
+		unsolved string
+	}{
+		{
+			name: "references and aliases",
+			config: `
+vars:
+  values: {kind: LocalConfiguration, name: values, fieldPath: data}
+`,
+			input: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  repoURL: $(ConfigMap/configuration-map:data.repoURL)
+  revision: "$(ConfigMap/configuration-map:data.targetRevision)"
+  replicas: $(ConfigMap/configuration-map:data.replicas)
+  values: |
+    expose: ${values:traefik.expose}
+    url: $(ConfigMap/configuration-map:data.repoURL)#$(ConfigMap/configuration-map:data.targetRevision)
+    host: ${HOST:localhost}
+    escaped: $$(ConfigMap/configuration-map:data.repoURL)
+`,
+			want: []string{
+				"  repoURL: https://github.com/karmafun/karmafun.git\n",
+				"  revision: \"deploy/citest\"\n",
+				"  replicas: 3\n",
+				"    expose: true\n",
+				"    url: https://github.com/karmafun/karmafun.git#deploy/citest\n",
+				"    host: ${HOST:localhost}\n",
+				"    escaped: $(ConfigMap/configuration-map:data.repoURL)\n",
+			},
+		},
+		{
+			name: "namespaced reference",
+			input: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  expose: $(LocalConfiguration/argocd/values:data.traefik.expose)
+`,
+			want: []string{"  expose: true\n"},
+		},
+		{
+			name: "unresolved references",
+			config: `
+vars:
+  values: {kind: LocalConfiguration, name: values, fieldPath: data}
+`,
+			input: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  repoURL: $(ConfigMap/configuration-map:data.repoURL)
+  missing: $(ConfigMap/missing:data.repoURL)
+  field: $(ConfigMap/configuration-map:data.missing)
+  structured: ${values:traefik}
+`,
+			wantErr: []string{
+				"in field data.missing of ConfigMap.v1.[noGrp]/app.[noNs]: unresolved reference $(ConfigMap/missing:data.repoURL): nothing selected",
+				"unresolved reference $(ConfigMap/configuration-map:data.missing): field data.missing not found",
+				"unresolved reference ${values:traefik}: field data.traefik of",
+			},
+			unsolved: "  repoURL: $(ConfigMap/configuration-map:data.repoURL)\n",
+		},
+		{
+			name: "circular reference",
+			input: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  first: $(ConfigMap/app:data.second)
+  second: $(ConfigMap/app:data.first)
+`,
+			wantErr: []string{
+				"reference $(ConfigMap/app:data.second) is circular: " +
+					"$(ConfigMap/app:data.second) -> $(ConfigMap/app:data.first) -> $(ConfigMap/app:data.second)",
+			},
+			unsolved: "  first: $(ConfigMap/app:data.second)\n",
+		},
+		{
+			name: "quoted values in embedded yaml",
+			input: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  note: $(ConfigMap/configuration-map:data.note)
+  values: |
+    note: $(ConfigMap/configuration-map:data.note)
+    replicas: $(ConfigMap/configuration-map:data.replicas)
+`,
+			want: []string{
+				"  note: 'warning: keep the quotes'\n",
+				"    note: 'warning: keep the quotes'\n    replicas: 3\n",
+			},
+		},
+		{
+			name:  "maximum depth",
+			input: chainedVars(12),
+			wantErr: []string{
+				"reference $(ConfigMap/app:data.f11) exceeds the maximum depth of 10",
+			},
+			unsolved: "  f0: $(ConfigMap/app:data.f1)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := require.New(t)
+
+			helpers, err := plugins.NewPluginHelpers()
+			req.NoError(err)
+			p, ok := extras.NewVarsTransformerPlugin().(*extras.VarsTransformerPlugin)
+			req.True(ok)
+			req.NoError(p.Config(helpers, []byte(tt.config)))
+
+			nodes, err := kio.FromBytes([]byte(varsResources + "---\n" + tt.input))
+			req.NoError(err)
+			rm := utils.ResourceMapFromNodes(nodes)
+
+			err = p.Transform(rm)
+			var b bytes.Buffer
+			req.NoError(kio.ByteWriter{Writer: &b}.Write(rm.ToRNodeSlice()))
+			if len(tt.wantErr) > 0 {
+				for _, want := range tt.wantErr {
+					req.ErrorContains(err, want)
+				}
+				req.Contains(b.String(), tt.unsolved, "resources should be left untouched")
+				return
+			}
+			req.NoError(err)
+			for _, want := range tt.want {
+				req.Contains(b.String(), want)
+			}
+		})
+	}
+}
+
+// chainedVars returns a ConfigMap whose fields reference the next one, the
+// last of the n fields having a value.
+func chainedVars(n int) string {
+	var b strings.Builder
+	b.WriteString("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\ndata:\n")
+	for i := range n - 1 {
+		fmt.Fprintf(&b, "  f%d: $(ConfigMap/app:data.f%d)\n", i, i+1)
+	}
+	fmt.Fprintf(&b, "  f%d: value\n", n-1)
+	return b.String()
+}
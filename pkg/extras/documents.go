@@ -0,0 +1,211 @@
+package extras
+
+import (
+	"fmt"
+	"slices"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// embeddedDocument is a data structure embedded in a scalar field, or in
+// another embedded document, that is parsed once and then modified in place
+// by successive extended path operations.
+type embeddedDocument struct {
+	encoding string
+	extender Extender
+	// path is the path of the document in its parent. It is nil for the
+	// documents embedded directly in a field.
+	path     []string
+	children []*embeddedDocument
+	// dirty is true if the document has been modified since it was last
+	// saved in its parent or field.
+	dirty bool
+}
+
+// fieldDocument is the document embedded in a scalar field.
+type fieldDocument struct {
+	*embeddedDocument
+	// resource is the resource containing the field.
+	resource *yaml.Node
+	// synced is the field value the document has been parsed from or last
+	// saved to. A different value means that the field has been modified
+	// outside the cache.
+	synced string
+}
+
+// documentCache keeps the embedded documents of the fields written with
+// extended paths during a filter run. Each document is parsed once and
+// serialized back when the field or its resource is flushed.
+type documentCache struct {
+	fields     map[*yaml.Node]*fieldDocument
+	byResource map[*yaml.Node][]*yaml.Node
+}
+
+// newDocumentCache returns an empty document cache.
+func newDocumentCache() *documentCache {
+	return &documentCache{
+		fields:     map[*yaml.Node]*fieldDocument{},
+		byResource: map[*yaml.Node][]*yaml.Node{},
+	}
+}
+
+// newEmbeddedDocument parses payload with the extender of encoding.
+func newEmbeddedDocument(encoding string, path []string, payload []byte) (*embeddedDocument, error) {
+	segment := &ExtendedSegment{Encoding: encoding, Path: path}
+	extender, err := segment.Extender(payload)
+	if err != nil {
+		return nil, fmt.Errorf("creating extender for %s: %w", segment.String(), err)
+	}
+	return &embeddedDocument{encoding: encoding, extender: extender, path: path}, nil
+}
+
+// root returns the document of encoding embedded in the scalar field of
+// resource, parsing it if needed.
+func (c *documentCache) root(resource, field *yaml.Node, encoding string) (*embeddedDocument, error) {
+	if cached, ok := c.fields[field]; ok {
+		if cached.encoding == encoding && cached.synced == field.Value {
+			return cached.embeddedDocument, nil
+		}
+		if cached.synced == field.Value {
+			// another encoding of the same field
+			if err := c.flushField(field); err != nil {
+				return nil, err
+			}
+		}
+		c.forget(field)
+	}
+	doc, err := newEmbeddedDocument(encoding, nil, []byte(field.Value))
+	if err != nil {
+		return nil, err
+	}
+	c.fields[field] = &fieldDocument{embeddedDocument: doc, resource: resource, synced: field.Value}
+	c.byResource[resource] = append(c.byResource[resource], field)
+	return doc, nil
+}
+
+// child returns the document of encoding embedded at path in parent, parsing
+// it if needed. Children of parent overlapping path are saved in parent and
+// dropped, as they would not see the modifications made through the returned
+// document.
+func (c *documentCache) child(parent *embeddedDocument, path []string, encoding string) (*embeddedDocument, error) {
+	for _, child := range parent.children {
+		if child.encoding == encoding && slices.Equal(child.path, path) {
+			return child, nil
+		}
+	}
+	if err := parent.save(); err != nil {
+		return nil, err
+	}
+	parent.children = slices.DeleteFunc(parent.children, func(child *embeddedDocument) bool {
+		return overlaps(child.path, path)
+	})
+	payload, err := parent.extender.Get(path)
+	if err != nil {
+		return nil, fmt.Errorf("getting value on path %s: %w", (&ExtendedSegment{Encoding: parent.encoding, Path: path}).String(), err)
+	}
+	doc, err := newEmbeddedDocument(encoding, path, payload)
+	if err != nil {
+		return nil, err
+	}
+	parent.children = append(parent.children, doc)
+	return doc, nil
+}
+
+// overlaps returns true if one of the paths is a prefix of the other.
+func overlaps(a, b []string) bool {
+	n := min(len(a), len(b))
+	return slices.Equal(a[:n], b[:n])
+}
+
+// save saves the modified children of d in d.
+func (d *embeddedDocument) save() error {
+	for _, child := range d.children {
+		if err := child.save(); err != nil {
+			return err
+		}
+		if !child.dirty {
+			continue
+		}
+		payload, err := child.extender.GetPayload()
+		if err != nil {
+			return fmt.Errorf("while serializing embedded %s document: %w", child.encoding, err)
+		}
+		if err = d.extender.Set(child.path, payload); err != nil {
+			return fmt.Errorf("while saving embedded %s document: %w", child.encoding, err)
+		}
+		child.dirty = false
+		d.dirty = true
+	}
+	return nil
+}
+
+// modified must be called before modifying d. It saves the children of d and
+// drops them as they may not reflect the modification.
+func (d *embeddedDocument) modified() error {
+	if err := d.save(); err != nil {
+		return err
+	}
+	d.children = nil
+	return nil
+}
+
+// flushField saves the document embedded in field, if any, in the field
+// value.
+func (c *documentCache) flushField(field *yaml.Node) error {
+	cached, ok := c.fields[field]
+	if !ok || cached.synced != field.Value {
+		return nil
+	}
+	if err := cached.save(); err != nil {
+		return err
+	}
+	if !cached.dirty {
+		return nil
+	}
+	payload, err := cached.extender.GetPayload()
+	if err != nil {
+		return fmt.Errorf("while serializing embedded %s document: %w", cached.encoding, err)
+	}
+	field.Value = string(payload)
+	cached.synced = field.Value
+	cached.dirty = false
+	return nil
+}
+
+// forget drops the document embedded in field, without saving it.
+func (c *documentCache) forget(field *yaml.Node) {
+	cached, ok := c.fields[field]
+	if !ok {
+		return
+	}
+	delete(c.fields, field)
+	c.byResource[cached.resource] = slices.DeleteFunc(c.byResource[cached.resource], func(n *yaml.Node) bool {
+		return n == field
+	})
+}
+
+// flushResource saves the documents embedded in the fields of resource.
+func (c *documentCache) flushResource(resource *yaml.RNode) error {
+	if c == nil {
+		return nil
+	}
+	for _, field := range c.byResource[resource.YNode()] {
+		if err := c.flushField(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush saves all the documents of the cache in their fields.
+func (c *documentCache) flush() error {
+	if c == nil {
+		return nil
+	}
+	for field := range c.fields {
+		if err := c.flushField(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
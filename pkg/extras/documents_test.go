@@ -0,0 +1,104 @@
+package extras_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplacementCaching(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{
+			name: "source reads an embedded document written before",
+			config: `
+replacements:
+  - source: {kind: ConfigMap, fieldPath: data.targetRevision}
+    targets:
+      - select: {kind: Application}
+        fieldPaths: [spec.source.helm.values.!!yaml.common.targetRevision]
+  - source: {kind: Application, fieldPath: spec.source.helm.values}
+    targets:
+      - select: {kind: ConfigMap}
+        fieldPaths: [data.values]
+        options: {create: true}
+`,
+			want: []string{"  values: |\n    common:\n      targetRevision: deploy/citest\n"},
+		},
+		{
+			name: "nested documents",
+			config: `
+replacements:
+  - sourceValue: first.example.com
+    targets:
+      - select: {kind: Application}
+        fieldPaths: ['spec.source.helm.values.!!yaml.sish.!!regex.^HostName\s+(\S+)$.1']
+  - sourceValue: main
+    targets:
+      - select: {kind: Application}
+        fieldPaths: [spec.source.helm.values.!!yaml.common.targetRevision]
+        when: {equals: main}
+  - sourceValue: |
+      HostName second.example.com
+    targets:
+      - select: {kind: Application}
+        fieldPaths: [spec.source.helm.values.!!yaml.sish]
+        when: {equals: "HostName first.example.com\n"}
+  - sourceValue: third.example.com
+    targets:
+      - select: {kind: Application}
+        fieldPaths: ['spec.source.helm.values.!!yaml.sish.!!regex.^HostName\s+(second\S+)$.1']
+`,
+			want: []string{"          HostName third.example.com\n"},
+		},
+		{
+			name: "whole field written after an embedded document",
+			config: `
+replacements:
+  - sourceValue: feature
+    targets:
+      - select: {kind: Application}
+        fieldPaths: [spec.source.helm.values.!!yaml.common.targetRevision]
+  - sourceValue: "common: {}"
+    targets:
+      - select: {kind: Application}
+        fieldPaths: [spec.source.helm.values]
+  - sourceValue: release
+    targets:
+      - select: {kind: Application}
+        fieldPaths: [spec.source.helm.values.!!yaml.common.targetRevision]
+`,
+			want: []string{"      values: |\n        common: {targetRevision: release}\n"},
+		},
+		{
+			name: "renamed target",
+			config: `
+replacements:
+  - sourceValue: renamed
+    targets:
+      - select: {kind: Application}
+        fieldPaths: [metadata.name]
+  - sourceValue: deploy/renamed
+    targets:
+      - select: {kind: Application, name: renamed}
+        fieldPaths: [spec.source.targetRevision]
+`,
+			want: []string{"  name: renamed\n", "    targetRevision: deploy/renamed\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := require.New(t)
+			got, _, err := runReplacementTransformer(t, tt.config, replacementResources)
+			req.NoError(err)
+			for _, want := range tt.want {
+				req.Contains(got, want)
+			}
+		})
+	}
+}
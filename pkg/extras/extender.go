@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -16,6 +17,7 @@ import (
 	"sigs.k8s.io/kustomize/kyaml/errors"
 	"sigs.k8s.io/kustomize/kyaml/kio"
 	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	kyaml_utils "sigs.k8s.io/kustomize/kyaml/utils"
 	"sigs.k8s.io/kustomize/kyaml/yaml"
 )
 
@@ -41,6 +43,13 @@ type Extender interface {
 	Set(path []string, value any) error
 }
 
+// SequenceExtender is implemented by extenders allowing the insertion of
+// values in sequences of the embedded data structure.
+type SequenceExtender interface {
+	// Insert inserts value in the sequence at path according to options.
+	Insert(path []string, value any, options *SequenceOptions) error
+}
+
 // ExtendedSegment contains the path segment of a resource inside an embedded
 // data structure.
 type ExtendedSegment struct {
@@ -54,7 +63,7 @@ type ExtendedSegment struct {
 //
 //	!!yaml.common.targetRevision
 func (e *ExtendedSegment) String() string {
-	if len(e.Path) > 0 {
+	if len(e.Path) == 0 {
 		return fmt.Sprintf("!!%s", e.Encoding)
 	} else {
 		return fmt.Sprintf("!!%s.%s", e.Encoding, strings.Join(e.Path, "."))
@@ -76,6 +85,10 @@ const (
 	IniExtender
 )
 
+// ErrNoMatch is returned by extenders when the path doesn't match anything in
+// the payload.
+var ErrNoMatch = errors.Errorf("path matches nothing")
+
 // stringToExtenderTypeMap maps encoding names to the corresponding extender
 var stringToExtenderTypeMap map[string]ExtenderType
 
@@ -89,6 +102,8 @@ func getByteValue(value any) []byte {
 	switch v := value.(type) {
 	case *yaml.Node:
 		return []byte(v.Value)
+	case *TypedValue:
+		return []byte(v.Node.Value)
 	case []byte:
 		return v
 	case string:
@@ -157,6 +172,11 @@ func (e *yamlExtender) SetPayload(payload []byte) error {
 
 // serializeNode serialize one node into YAML
 func serializeNode(node *yaml.RNode) ([]byte, error) {
+	if node.YNode().Kind != yaml.MappingNode {
+		// the byte writer only handles mappings
+		out, err := node.String()
+		return []byte(out), err
+	}
 	var b bytes.Buffer
 	err := (&kio.ByteWriter{Writer: &b}).Write([]*yaml.RNode{node})
 	return b.Bytes(), err
@@ -177,10 +197,15 @@ func unwrapSeqNode(node *yaml.RNode) *yaml.RNode {
 }
 
 // Lookup looks for the specified path in node and return the matching node. If
-// kind is a valid node kind and the node doesn't exist, create it.
+// kind is a valid node kind and the node doesn't exist, create it. Keyed list
+// elements like [name=value] are created with their key field.
 func Lookup(node *yaml.RNode, path []string, kind yaml.Kind) (*yaml.RNode, error) {
 	// TODO: consider using yaml.PathGetter instead
-	node, err := unwrapSeqNode(node).Pipe(&yaml.PathGetter{Path: path, Create: kind})
+	node = unwrapSeqNode(node)
+	if kind != 0 {
+		prepareCreation(node, path)
+	}
+	node, err := node.Pipe(&yaml.PathGetter{Path: path, Create: kind})
 	if err != nil {
 		return nil, fmt.Errorf("while getting path %s: %w", strings.Join(path, "."), err)
 	}
@@ -192,11 +217,15 @@ func Lookup(node *yaml.RNode, path []string, kind yaml.Kind) (*yaml.RNode, error
 type nodeSerializer func(*yaml.RNode) ([]byte, error)
 
 // getNodePath returns the value of the node at path serialized with serializer.
+// It returns an error wrapping [ErrNoMatch] if there is no node at path.
 func getNodePath(node *yaml.RNode, path []string, serializer nodeSerializer) ([]byte, error) {
 	node, err := Lookup(node, path, 0)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching elements in replacement target: %w", err)
 	}
+	if node == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoMatch, strings.Join(path, "."))
+	}
 
 	if node.YNode().Kind == yaml.ScalarNode {
 		return []byte(node.YNode().Value), nil
@@ -210,12 +239,20 @@ func (e *yamlExtender) Get(path []string) ([]byte, error) {
 	return getNodePath(e.node, path, serializeNode)
 }
 
-// setValue sets value at path on node
+// setValue sets value at path on node.
+//
+// If the last element of path is "-" (resp. "+"), value is appended (resp.
+// prepended) to the sequence at the rest of the path.
 func setValue(node *yaml.RNode, path []string, value any) error {
+	if seqPath, options := sequenceOptionsFromPath(path); options != nil {
+		return insertValue(node, seqPath, value, options)
+	}
+
 	kind := yaml.ScalarNode
 	if v, ok := value.(*yaml.Node); ok {
 		kind = v.Kind
 	}
+	typed, isTyped := value.(*TypedValue)
 
 	target, err := Lookup(node, path, kind)
 	if err != nil {
@@ -225,9 +262,14 @@ func setValue(node *yaml.RNode, path []string, value any) error {
 	switch target.YNode().Kind {
 	case yaml.ScalarNode:
 		target.YNode().Value = string(getByteValue(value))
+		if isTyped {
+			target.YNode().Tag = typed.Node.Tag
+			target.YNode().Style = typed.Node.Style
+		}
 	case kind:
 		if v, isNode := value.(*yaml.Node); isNode {
 			target.SetYNode(v)
+			return setKeyField(target, path)
 		}
 	case yaml.DocumentNode, yaml.SequenceNode, yaml.MappingNode, yaml.AliasNode:
 	default:
@@ -242,10 +284,21 @@ func (e *yamlExtender) Set(path []string, value any) error {
 	return setValue(e.node, path, value)
 }
 
+// Insert inserts value in the sequence at the specified path.
+func (e *yamlExtender) Insert(path []string, value any, options *SequenceOptions) error {
+	return insertValue(e.node, path, value, options)
+}
+
+// MoveKey moves the field at the specified path to newPath.
+func (e *yamlExtender) MoveKey(path, newPath []string) error {
+	return moveKey(e.node, path, newPath)
+}
+
 // NewYamlExtender returns a newly created YAML [Extender].
 //
 // With this encoding, you can set scalar values (strings, numbers) as well
-// as mapping values.
+// as mapping values. A path ending with "-" appends the value to a sequence
+// while a path ending with "+" prepends it.
 func NewYamlExtender() Extender {
 	return &yamlExtender{}
 }
@@ -329,16 +382,33 @@ func (e *regexExtender) GetPayload() ([]byte, error) {
 }
 
 // Get returns the text matched by the regexp contained in the first segment of
-// path.
+// path. If path has a second segment, it returns the text of the corresponding
+// capture group of the first match.
+//
+// If the regexp doesn't match, an error wrapping [ErrNoMatch] is returned.
 func (e *regexExtender) Get(path []string) ([]byte, error) {
 	if len(path) < 1 {
 		return nil, fmt.Errorf("path for regex should at least be one")
 	}
-	re, err := regexp.Compile(path[0])
+	re, err := regexp.Compile("(?m)" + path[0])
 	if err != nil {
 		return nil, fmt.Errorf("bad regex %s", path[0])
 	}
-	return re.Find(e.text), nil
+	group := 0
+	if len(path) > 1 {
+		group, err = strconv.Atoi(path[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad capturing group")
+		}
+	}
+	match := re.FindSubmatch(e.text)
+	if match == nil {
+		return nil, fmt.Errorf("%w: regex %s", ErrNoMatch, path[0])
+	}
+	if group < 0 || group >= len(match) {
+		return nil, fmt.Errorf("bad capturing group")
+	}
+	return match[group], nil
 }
 
 // Set modifies the inner text inserting value in the capture group specified by
@@ -353,6 +423,9 @@ func (e *regexExtender) Get(path []string) ([]byte, error) {
 //	[`^\s+HostName\s+\S+\s*$`, `0`]
 //
 // Replace the whole line with value.
+//
+// If the regexp doesn't match, the text is left unchanged and an error
+// wrapping [ErrNoMatch] is returned.
 func (e *regexExtender) Set(path []string, value any) error {
 	if len(path) != 2 {
 		return fmt.Errorf("path for regex should at least be one")
@@ -380,13 +453,15 @@ func (e *regexExtender) Set(path []string, value any) error {
 		start = v[startIndex+1]
 	}
 
-	if matched {
-		if start < len(e.text) {
-			b.Write(e.text[start:])
-		}
-		e.text = b.Bytes()
+	if !matched {
+		return fmt.Errorf("%w: regex %s", ErrNoMatch, path[0])
 	}
 
+	if start < len(e.text) {
+		b.Write(e.text[start:])
+	}
+	e.text = b.Bytes()
+
 	return nil
 }
 
@@ -484,6 +559,16 @@ func (e *jsonExtender) Set(path []string, value any) error {
 	return setValue(e.node, path, value)
 }
 
+// Insert inserts value in the JSON array at path.
+func (e *jsonExtender) Insert(path []string, value any, options *SequenceOptions) error {
+	return insertValue(e.node, path, value, options)
+}
+
+// MoveKey moves the JSON property at path to newPath.
+func (e *jsonExtender) MoveKey(path, newPath []string) error {
+	return moveKey(e.node, path, newPath)
+}
+
 // NewJsonExtender returns a newly created [Extender] to modify JSON content.
 //
 // As with the YAML extender (see [NewYamlExtender]), modifications are not
@@ -549,6 +634,16 @@ func (e *tomlExtender) Set(path []string, value any) error {
 	return setValue(e.node, path, value)
 }
 
+// Insert inserts value in the TOML array at path.
+func (e *tomlExtender) Insert(path []string, value any, options *SequenceOptions) error {
+	return insertValue(e.node, path, value, options)
+}
+
+// MoveKey moves the TOML property at path to newPath.
+func (e *tomlExtender) MoveKey(path, newPath []string) error {
+	return moveKey(e.node, path, newPath)
+}
+
 // NewTomlExtender returns a newly created [Extender] for modifying properties
 // containing TOML.
 //
@@ -599,12 +694,22 @@ func (e *iniExtender) keyFromPath(path []string) (*ini.Key, error) {
 }
 
 // Get returns the content of the key specified by path.
+//
+// If the key doesn't exist, an error wrapping [ErrNoMatch] is returned.
 func (e *iniExtender) Get(path []string) ([]byte, error) {
-	k, err := e.keyFromPath(path)
-	if err != nil {
-		return nil, fmt.Errorf("while getting key at path %s: %w", strings.Join(path, "."), err)
+	if len(path) < 1 || len(path) > 2 {
+		return nil, fmt.Errorf("while getting key at path %s: invalid path length: %d",
+			strings.Join(path, "."), len(path))
+	}
+	section, key := "", path[0]
+	if len(path) == 2 {
+		section, key = path[0], path[1]
+	}
+	s, err := e.file.GetSection(section)
+	if err != nil || !s.HasKey(key) {
+		return nil, fmt.Errorf("%w: %s", ErrNoMatch, strings.Join(path, "."))
 	}
-	return []byte(k.String()), nil
+	return []byte(s.Key(key).String()), nil
 }
 
 // Set sets the value of the key specified by path with value.
@@ -716,9 +821,24 @@ func splitExtendedPath(path []string, extensions *[]*ExtendedSegment) ([]string,
 //	     &ExtendedSegment{Encoding: "base64", Path: []string{}},
 //	     &ExtendedSegment{Encoding: "yaml", Path: []string{"common", "URL"}},
 //	 }
+//
+// If Sequence is not nil, the value is inserted in the sequence designated by
+// the last segment instead of replacing it. If Typed is true, the value keeps
+// its own tag instead of taking the one of the field it replaces. If RenameKey
+// is true, the value renames the key of the field designated by the last
+// segment. If KeyPath is also true, the value is the path where the field is
+// moved, from the root of the document of the last segment.
 type ExtendedPath struct {
 	ExtendedSegments *[]*ExtendedSegment
+	Sequence         *SequenceOptions
 	ResourcePath     []string
+	Typed            bool
+	RenameKey        bool
+	KeyPath          bool
+	// documents, when set, keeps the embedded documents parsed across
+	// operations on the fields of resource.
+	documents *documentCache
+	resource  *yaml.Node
 }
 
 // NewExtendedPath creates an [ExtendedPath] from the split path segments in paths.
@@ -732,6 +852,17 @@ func NewExtendedPath(path []string) (*ExtendedPath, error) {
 	return &ExtendedPath{ResourcePath: prefix, ExtendedSegments: &extensions}, nil
 }
 
+// DecodeBase64 makes ep decode the base64 content of the resource field before
+// applying the extended segments and encode the result back. It does nothing
+// if the path already starts with a base64 segment.
+func (ep *ExtendedPath) DecodeBase64() {
+	segments := *ep.ExtendedSegments
+	if len(segments) > 0 && getExtenderType(segments[0].Encoding) == Base64Extender {
+		return
+	}
+	*ep.ExtendedSegments = append([]*ExtendedSegment{{Encoding: "base64", Path: []string{}}}, segments...)
+}
+
 // HasExtensions returns true if the path contains extended segments.
 func (ep *ExtendedPath) HasExtensions() bool {
 	return len(*ep.ExtendedSegments) > 0
@@ -750,6 +881,30 @@ func (ep *ExtendedPath) String() string {
 	return out
 }
 
+// Get returns the current value designated by the extended segments in the
+// scalar target. It returns an error wrapping [ErrNoMatch] if the path
+// matches nothing.
+func (ep *ExtendedPath) Get(target *yaml.RNode) ([]byte, error) {
+	if target.YNode().Kind != yaml.ScalarNode {
+		return nil, fmt.Errorf("extended path only works on scalar nodes")
+	}
+	if ep.documents != nil && ep.HasExtensions() {
+		return ep.getCached(target)
+	}
+	value := []byte(target.YNode().Value)
+	for _, segment := range *ep.ExtendedSegments {
+		extender, err := segment.Extender(value)
+		if err != nil {
+			return nil, fmt.Errorf("creating extender for %s: %w", segment.String(), err)
+		}
+		value, err = extender.Get(segment.Path)
+		if err != nil {
+			return nil, fmt.Errorf("getting value on path %s: %w", segment.String(), err)
+		}
+	}
+	return value, nil
+}
+
 // applyIndex applies value to input starting at the extended path index.
 func (ep *ExtendedPath) applyIndex(index int, input []byte, value *yaml.Node) ([]byte, error) {
 	if index >= len(*ep.ExtendedSegments) || index < 0 {
@@ -774,8 +929,14 @@ func (ep *ExtendedPath) applyIndex(index int, input []byte, value *yaml.Node) ([
 		if err != nil {
 			return nil, err
 		}
+	} else if ep.Typed {
+		newValue = &TypedValue{Node: value}
+	}
+	if index == len(*ep.ExtendedSegments)-1 {
+		err = ep.setLast(extender, segment, newValue)
+	} else {
+		err = extender.Set(segment.Path, newValue)
 	}
-	err = extender.Set(segment.Path, newValue)
 	if err != nil {
 		return nil, fmt.Errorf("setting value on path %s: %w", segment.String(), err)
 	}
@@ -783,6 +944,95 @@ func (ep *ExtendedPath) applyIndex(index int, input []byte, value *yaml.Node) ([
 	return extender.GetPayload()
 }
 
+// setLast sets value in extender at the path of the last segment, renaming
+// the key or inserting in a sequence if ep asks for it.
+func (ep *ExtendedPath) setLast(extender Extender, segment *ExtendedSegment, value any) error {
+	switch {
+	case ep.RenameKey:
+		keyExtender, ok := extender.(KeyExtender)
+		if !ok {
+			return fmt.Errorf("extender %s doesn't support key renaming", segment.Encoding)
+		}
+		if len(segment.Path) == 0 {
+			return fmt.Errorf("cannot rename the key of the root node")
+		}
+		newKey := string(getByteValue(value))
+		newPath := append(slices.Clone(segment.Path[:len(segment.Path)-1]), newKey)
+		if ep.KeyPath {
+			newPath = kyaml_utils.SmarterPathSplitter(newKey, ".")
+		}
+		//nolint:wrapcheck // We want to preserve the error type returned by the extender
+		return keyExtender.MoveKey(segment.Path, newPath)
+	case ep.Sequence != nil:
+		sequenceExtender, ok := extender.(SequenceExtender)
+		if !ok {
+			return fmt.Errorf("extender %s doesn't support sequence insertion", segment.Encoding)
+		}
+		//nolint:wrapcheck // We want to preserve the error type returned by the extender
+		return sequenceExtender.Insert(segment.Path, value, ep.Sequence)
+	}
+	//nolint:wrapcheck // We want to preserve the error type returned by the extender
+	return extender.Set(segment.Path, value)
+}
+
+// document returns the cached document addressed by the last extended segment
+// in the scalar target.
+func (ep *ExtendedPath) document(target *yaml.RNode) (*embeddedDocument, error) {
+	segments := *ep.ExtendedSegments
+	doc, err := ep.documents.root(ep.resource, target.YNode(), segments[0].Encoding)
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(segments); i++ {
+		doc, err = ep.documents.child(doc, segments[i-1].Path, segments[i].Encoding)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// getCached is the [ExtendedPath.Get] counterpart using the document cache.
+func (ep *ExtendedPath) getCached(target *yaml.RNode) ([]byte, error) {
+	doc, err := ep.document(target)
+	if err != nil {
+		return nil, err
+	}
+	if err = doc.save(); err != nil {
+		return nil, err
+	}
+	segment := (*ep.ExtendedSegments)[len(*ep.ExtendedSegments)-1]
+	value, err := doc.extender.Get(segment.Path)
+	if err != nil {
+		return nil, fmt.Errorf("getting value on path %s: %w", segment.String(), err)
+	}
+	return value, nil
+}
+
+// applyCached is the [ExtendedPath.Apply] counterpart using the document
+// cache. The modified documents are only serialized in target when the cache
+// is flushed.
+func (ep *ExtendedPath) applyCached(target, value *yaml.RNode) error {
+	doc, err := ep.document(target)
+	if err != nil {
+		return fmt.Errorf("applying value on extended segment %s: %w", ep.String(), err)
+	}
+	if err = doc.modified(); err != nil {
+		return err
+	}
+	var newValue any = value.YNode()
+	if ep.Typed {
+		newValue = &TypedValue{Node: value.YNode()}
+	}
+	segment := (*ep.ExtendedSegments)[len(*ep.ExtendedSegments)-1]
+	if err = ep.setLast(doc.extender, segment, newValue); err != nil {
+		return fmt.Errorf("applying value on extended segment %s: setting value on path %s: %w",
+			ep.String(), segment.String(), err)
+	}
+	doc.dirty = true
+	return nil
+}
+
 // Apply applies value to target. target is the KRM resource specified by
 // ResourcePrefix.
 //
@@ -795,6 +1045,9 @@ func (ep *ExtendedPath) Apply(target, value *yaml.RNode) error {
 		return fmt.Errorf("extended path only works on scalar nodes")
 	}
 
+	if ep.documents != nil && ep.HasExtensions() {
+		return ep.applyCached(target, value)
+	}
 	outValue := value.YNode().Value
 	if len(*ep.ExtendedSegments) > 0 {
 		input := []byte(target.YNode().Value)
@@ -804,6 +1057,9 @@ func (ep *ExtendedPath) Apply(target, value *yaml.RNode) error {
 		}
 
 		outValue = string(output)
+	} else if ep.Typed {
+		target.YNode().Tag = value.YNode().Tag
+		target.YNode().Style = value.YNode().Style
 	}
 	target.YNode().Value = outValue
 	return nil
@@ -466,3 +466,37 @@ enabled = true
 	req.NoError(err)
 	req.Equal("deploy/citest", string(value), "error fetching changed value")
 }
+
+func TestJsonExtenderSequenceInsertion(t *testing.T) {
+	t.Parallel()
+	req := require.New(t)
+	source := `{
+  "hosts": ["old.example.com"]
+}`
+	expected := `{
+  "hosts": [
+    "first.example.com",
+    "old.example.com",
+    "new.example.com"
+  ]
+}
+`
+
+	jsonXP := &ExtendedSegment{Encoding: "json", Path: []string{"hosts", "-"}}
+	jsonExt, err := jsonXP.Extender([]byte(source))
+	req.NoError(err)
+	req.NoError(jsonExt.Set(jsonXP.Path, []byte("new.example.com")))
+	req.NoError(jsonExt.Set([]string{"hosts", "+"}, []byte("first.example.com")))
+
+	sequenceExt, ok := jsonExt.(SequenceExtender)
+	req.True(ok, "json extender should support sequence insertion")
+	req.NoError(sequenceExt.Insert(
+		[]string{"hosts"},
+		[]byte("old.example.com"),
+		&SequenceOptions{Index: -1, Unique: true},
+	))
+
+	modified, err := jsonExt.GetPayload()
+	req.NoError(err)
+	req.Equal(expected, string(modified), "final json")
+}
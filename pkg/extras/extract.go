@@ -0,0 +1,520 @@
+package extras
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/resid"
+	kyaml_utils "sigs.k8s.io/kustomize/kyaml/utils"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// DirectionApply is the default direction of the replacements. It copies
+	// the source values to the targets.
+	DirectionApply = "apply"
+	// DirectionExtract reverses the replacements. It copies the values of the
+	// targets back to their source.
+	DirectionExtract = "extract"
+)
+
+// extractionFile is a resource file receiving the values extracted from the
+// targets.
+type extractionFile struct {
+	// path is the path of the file as configured.
+	path string
+	// location is the path of the file in fSys.
+	location string
+	// fSys is the file system the file is read from and written to.
+	fSys     filesys.FileSystem
+	nodes    []*yaml.RNode
+	modified bool
+}
+
+// isRemoteFile returns true if path is the URL of a file loaded over HTTP.
+func isRemoteFile(path string) bool {
+	u, err := url.Parse(path)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// loadExtractionFiles loads the resource files of paths from fSys. The files
+// already present in loaded are reused and the new ones are added to it.
+// Virtual sources, remote files and kustomizations cannot receive extracted
+// values.
+func loadExtractionFiles(
+	h *resmap.PluginHelpers,
+	fSys filesys.FileSystem,
+	paths SourcePaths,
+	loaded map[string]*extractionFile,
+) ([]*extractionFile, error) {
+	if repo := h.Loader().Repo(); repo != "" {
+		return nil, fmt.Errorf("cannot extract values into remote repository %s", repo)
+	}
+	root := h.Loader().Root()
+	expanded, err := expandSourcePaths(root, paths)
+	if err != nil {
+		return nil, err
+	}
+	var result []*extractionFile
+	for _, path := range expanded {
+		if isVirtualSource(path) {
+			return nil, fmt.Errorf("cannot extract values into virtual source %s", path)
+		}
+		if isRemoteFile(path) {
+			return nil, fmt.Errorf("cannot extract values into remote file %s", path)
+		}
+		if file, ok := loaded[path]; ok {
+			result = append(result, file)
+			continue
+		}
+		location := path
+		if !filepath.IsAbs(location) {
+			location = filepath.Join(root, location)
+		}
+		if fSys.IsDir(location) {
+			return nil, fmt.Errorf("cannot extract values into kustomization %s", path)
+		}
+		content, loadErr := fSys.ReadFile(location)
+		if loadErr != nil {
+			return nil, fmt.Errorf("while loading resource file %s: %w", path, loadErr)
+		}
+		nodes, readErr := (&kio.ByteReader{Reader: bytes.NewReader(content), PreserveSeqIndent: true}).Read()
+		if readErr != nil {
+			return nil, fmt.Errorf("while reading resource file %s: %w", path, readErr)
+		}
+		file := &extractionFile{path: path, location: location, fSys: fSys, nodes: nodes}
+		loaded[path] = file
+		result = append(result, file)
+	}
+	return result, nil
+}
+
+// serialize returns the content of e with its modified resources.
+func (e *extractionFile) serialize() ([]byte, error) {
+	var b bytes.Buffer
+	if err := (kio.ByteWriter{Writer: &b}).Write(e.nodes); err != nil {
+		return nil, fmt.Errorf("while serializing resource file %s: %w", e.path, err)
+	}
+	return b.Bytes(), nil
+}
+
+// save writes content back to the file of e.
+func (e *extractionFile) save(content []byte) error {
+	if err := e.fSys.WriteFile(e.location, content); err != nil {
+		return fmt.Errorf("while saving resource file %s: %w", e.path, err)
+	}
+	e.modified = false
+	return nil
+}
+
+// extractedValue is the value of a target field.
+type extractedValue struct {
+	node *yaml.RNode
+	// origin describes the target field the value comes from.
+	origin string
+}
+
+// nodeText returns the value of a scalar node or the serialization of a
+// structured node.
+func nodeText(n *yaml.RNode) string {
+	if n.YNode().Kind == yaml.ScalarNode {
+		return n.YNode().Value
+	}
+	return strings.TrimSuffix(n.MustString(), "\n")
+}
+
+// extractReplacements copies the values of the targets of each replacement
+// back to its source. A failing replacement doesn't stop the following ones,
+// and the returned error joins the errors of all the failing replacements.
+func (f *extendedFilter) extractReplacements(nodes []*yaml.RNode) error {
+	var errs []error
+	for i := range f.Replacements {
+		if err := f.extractReplacement(nodes, i); err != nil {
+			errs = append(errs, fmt.Errorf("replacement %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// extractReplacement copies the value of the targets of replacement back to
+// its source. When the targets disagree, a warning is added to the results
+// and the source is left unchanged. In strict mode, it is an error.
+func (f *extendedFilter) extractReplacement(nodes []*yaml.RNode, replacement int) error {
+	r := f.Replacements[replacement]
+	if (r.Source == nil && r.SourceValue == nil) || r.Targets == nil {
+		return fmt.Errorf("replacements must specify a source and at least one target")
+	}
+	if r.Source != nil && r.SourceValue != nil {
+		return fmt.Errorf("source and sourceValue are mutually exclusive")
+	}
+	if r.Source == nil {
+		f.results = append(f.results, &framework.Result{
+			Message:  fmt.Sprintf("replacement %d has a source value and no source to extract into", replacement),
+			Severity: framework.Info,
+		})
+		return nil
+	}
+	if r.Source.isCorrelated() {
+		return fmt.Errorf("values cannot be extracted into a correlated source")
+	}
+
+	values, err := f.targetValues(nodes, replacement)
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		if f.Strict {
+			return fmt.Errorf("targets have no value to extract")
+		}
+		f.results = append(f.results, &framework.Result{
+			Message:  fmt.Sprintf("targets of replacement %d have no value to extract", replacement),
+			Severity: framework.Info,
+		})
+		return nil
+	}
+
+	distinct := map[string]bool{}
+	for _, v := range values {
+		distinct[nodeText(v.node)] = true
+	}
+	if len(distinct) > 1 {
+		details := make([]string, 0, len(values))
+		for _, v := range values {
+			details = append(details, fmt.Sprintf("%q in %s", nodeText(v.node), v.origin))
+		}
+		if f.Strict {
+			return fmt.Errorf("targets disagree: %s", strings.Join(details, ", "))
+		}
+		f.results = append(f.results, &framework.Result{
+			Message: fmt.Sprintf("targets of replacement %d disagree, source left unchanged: %s",
+				replacement, strings.Join(details, ", ")),
+			Severity: framework.Warning,
+			Field:    &framework.Field{Path: r.Source.FieldPath},
+		})
+		return nil
+	}
+	return f.extractValue(nodes, replacement, values[0])
+}
+
+// targetValues returns the values of the fields selected by the targets of
+// replacement.
+func (f *extendedFilter) targetValues(nodes []*yaml.RNode, replacement int) ([]extractedValue, error) {
+	var values []extractedValue
+	for target, selector := range f.Replacements[replacement].Targets {
+		if selector.Select == nil {
+			return nil, fmt.Errorf("target must specify resources to select")
+		}
+		if len(selector.FieldPaths) == 0 {
+			selector.FieldPaths = []string{types.DefaultReplacementFieldPath}
+		}
+		if selector.Options.isRenameKey() {
+			return nil, fmt.Errorf("target %d: renamed keys cannot be extracted", target)
+		}
+		sequence, err := selector.Options.sequenceOptions()
+		if err != nil {
+			return nil, err
+		}
+		if sequence != nil {
+			return nil, fmt.Errorf("target %d: values inserted in sequences cannot be extracted", target)
+		}
+		index, err := f.resourceIndex(nodes)
+		if err != nil {
+			return nil, err
+		}
+		selected := false
+		for _, candidate := range index.candidates(selector.Select.ResId, selector.Select.LabelSelector) {
+			entry := index.entries[candidate]
+			selectByAnnoAndLabel, err := selectByAnnoAndLabel(entry.node, selector)
+			if err != nil {
+				return nil, err
+			}
+			if !selectByAnnoAndLabel || !isSelectedId(entry.ids, selector) {
+				continue
+			}
+			selected = true
+			found, err := f.readTarget(entry.node, selector)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, found...)
+		}
+		if selector.isStrict(f.Strict) && !selected {
+			return nil, fmt.Errorf("target selector %s matches no resource", selector.Select)
+		}
+	}
+	return values, nil
+}
+
+// readTarget returns the values of the fields of target selected by the field
+// paths of selector. It reverses the transformations made when writing them.
+func (f *extendedFilter) readTarget(target *yaml.RNode, selector *TargetSelector) ([]extractedValue, error) {
+	strict := selector.isStrict(f.Strict)
+	condition, err := selector.When.compile()
+	if err != nil {
+		return nil, err
+	}
+	var values []extractedValue
+	for _, fp := range selector.FieldPaths {
+		fieldPath := kyaml_utils.SmarterPathSplitter(fp, ".")
+		extendedPath, err := NewExtendedPath(fieldPath)
+		if err != nil {
+			return nil, err
+		}
+		decode := !f.RawSecretData && !selector.Options.isRaw() && isBase64Field(target, extendedPath.ResourcePath)
+		if decode {
+			extendedPath.DecodeBase64()
+		}
+		fields, err := lookupFields(target, extendedPath.ResourcePath)
+		if err != nil {
+			return nil, err
+		}
+		if strict && len(fields) == 0 {
+			return nil, fmt.Errorf("field path %s matches nothing in %s", fp, resid.FromRNode(target))
+		}
+		for _, field := range fields {
+			if condition != nil {
+				holds, conditionErr := conditionHolds(condition, field, extendedPath)
+				if conditionErr != nil {
+					return nil, fmt.Errorf("while evaluating condition on field path %s in %s: %w",
+						fp, resid.FromRNode(target), conditionErr)
+				}
+				if !holds {
+					continue
+				}
+			}
+			value, err := readField(field, extendedPath, decode)
+			if !strict && errors.Is(err, ErrNoMatch) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("while reading field path %s in %s: %w", fp, resid.FromRNode(target), err)
+			}
+			if options := selector.Options; options != nil && options.Delimiter != "" {
+				if value.YNode().Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("delimiter option can only be used with scalar nodes")
+				}
+				parts := strings.Split(value.YNode().Value, options.Delimiter)
+				// a negative index prepends the value and an index past the
+				// end appends it
+				part := min(max(options.Index, 0), len(parts)-1)
+				value = yaml.NewScalarRNode(parts[part])
+			}
+			values = append(values, extractedValue{
+				node:   value,
+				origin: fmt.Sprintf("%s field %s", resid.FromRNode(target), fp),
+			})
+		}
+	}
+	return values, nil
+}
+
+// readField returns the value of field at extendedPath, base64 decoding it
+// if decode is true.
+func readField(field *yaml.RNode, extendedPath *ExtendedPath, decode bool) (*yaml.RNode, error) {
+	if extendedPath.HasExtensions() {
+		value, err := extendedPath.Get(field)
+		if err != nil {
+			return nil, err
+		}
+		return yaml.NewScalarRNode(string(value)), nil
+	}
+	if decode {
+		return decodeBase64Value(field)
+	}
+	return field.Copy(), nil
+}
+
+// extractValue writes value in the source field of replacement.
+func (f *extendedFilter) extractValue(nodes []*yaml.RNode, replacement int, value extractedValue) error {
+	selector := f.Replacements[replacement].Source
+	if selector.FieldPath == "" {
+		selector.FieldPath = types.DefaultReplacementFieldPath
+	}
+	if selector.Options != nil && selector.Options.Encoding != "" {
+		return fmt.Errorf("values cannot be extracted into a source with the encoding option")
+	}
+	fieldPath := kyaml_utils.SmarterPathSplitter(selector.FieldPath, ".")
+	source, file, err := f.extractionSource(nodes, replacement, fieldPath)
+	if err != nil {
+		return err
+	}
+
+	newValue := value.node
+	field, err := source.Pipe(yaml.LookupCreate(newValue.YNode().Kind, fieldPath...))
+	if err != nil {
+		return fmt.Errorf("while creating source field %s: %w", selector.FieldPath, err)
+	}
+	if options := selector.Options; options != nil && options.Delimiter != "" {
+		if field.YNode().Kind != yaml.ScalarNode {
+			return fmt.Errorf("delimiter option can only be used with scalar nodes")
+		}
+		parts := strings.Split(field.YNode().Value, options.Delimiter)
+		if options.Index >= len(parts) || options.Index < 0 {
+			return fmt.Errorf("options.index %d is out of bounds for value %s", options.Index, field.YNode().Value)
+		}
+		parts[options.Index] = nodeText(newValue)
+		newValue = yaml.NewScalarRNode(strings.Join(parts, options.Delimiter))
+	}
+	if !f.RawSecretData && isBase64Field(source, fieldPath) && newValue.YNode().Kind == yaml.ScalarNode {
+		newValue = yaml.NewStringRNode(base64.StdEncoding.EncodeToString([]byte(newValue.YNode().Value)))
+	}
+
+	previous, proposed := nodeText(field), nodeText(newValue)
+	if previous == proposed {
+		return nil
+	}
+	if field.YNode().Kind == yaml.ScalarNode && newValue.YNode().Kind == yaml.ScalarNode {
+		// keep the style and type of the source field
+		field.YNode().Value = newValue.YNode().Value
+		if field.YNode().Tag == "" {
+			field.YNode().Tag = newValue.YNode().Tag
+		}
+	} else {
+		field.SetYNode(newValue.Copy().YNode())
+	}
+
+	report := &framework.Result{
+		Message:     fmt.Sprintf("replacement %d extracted %s", replacement, value.origin),
+		Severity:    framework.Info,
+		ResourceRef: resourceRef(source),
+		Field:       &framework.Field{Path: selector.FieldPath, CurrentValue: previous, ProposedValue: proposed},
+		File:        resourceFile(source),
+		Tags:        map[string]string{"replacement": strconv.Itoa(replacement), "extracted": "true"},
+	}
+	if isSecret(source) {
+		report.Field.CurrentValue, report.Field.ProposedValue = redactedValue, redactedValue
+	}
+	if file != nil {
+		file.modified = true
+		report.File = &framework.File{Path: file.path}
+	}
+	f.results = append(f.results, report)
+	return nil
+}
+
+// extractionSource returns the source resource of replacement and the file
+// containing it. When the replacement has no source file, the source is
+// selected in nodes and the returned file is nil.
+//
+// When the source is layered, the values are extracted into the last layer
+// defining the source field, or into the last layer containing the source
+// resource if none defines it.
+func (f *extendedFilter) extractionSource(
+	nodes []*yaml.RNode,
+	replacement int,
+	fieldPath []string,
+) (*yaml.RNode, *extractionFile, error) {
+	selector := f.Replacements[replacement].Source
+	var files []*extractionFile
+	if replacement < len(f.extractionFiles) {
+		files = f.extractionFiles[replacement]
+	}
+	if len(files) == 0 {
+		index, err := f.resourceIndex(nodes)
+		if err != nil {
+			return nil, nil, err
+		}
+		source, err := selectSourceNode(nodes, index, selector)
+		return source, nil, err
+	}
+
+	var fallback *yaml.RNode
+	var fallbackFile *extractionFile
+	for _, file := range slices.Backward(files) {
+		source, err := selectSourceNode(file.nodes, nil, selector)
+		if errors.Is(err, errNothingSelected) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("in %s: %w", file.path, err)
+		}
+		field, err := source.Pipe(yaml.Lookup(fieldPath...))
+		if err != nil {
+			return nil, nil, fmt.Errorf("error looking up replacement source in %s: %w", file.path, err)
+		}
+		if field != nil {
+			return source, file, nil
+		}
+		if fallback == nil {
+			fallback, fallbackFile = source, file
+		}
+	}
+	if fallback == nil {
+		paths := make([]string, 0, len(files))
+		for _, file := range files {
+			paths = append(paths, file.path)
+		}
+		return nil, nil, fmt.Errorf("%w by %s in %s", errNothingSelected, selector, strings.Join(paths, ", "))
+	}
+	return fallback, fallbackFile, nil
+}
+
+// extract copies the values of the targets in m back to the replacement
+// sources and saves the modified source files.
+func (p *ExtendedReplacementTransformerPlugin) extract(m resmap.ResMap) error {
+	// the plugin helpers don't expose the file system of their loader
+	fSys := p.FileSystem
+	if fSys == nil {
+		fSys = filesys.MakeFsOnDisk()
+	}
+	loaded := map[string]*extractionFile{}
+	files := make([][]*extractionFile, len(p.Replacements))
+	for i, r := range p.Replacements {
+		paths := p.Source
+		if len(r.From) > 0 {
+			paths = r.From
+		}
+		var err error
+		files[i], err = loadExtractionFiles(p.h, fSys, paths, loaded)
+		if err != nil {
+			return fmt.Errorf("while loading source of replacement %d: %w", i, err)
+		}
+	}
+
+	filter := &extendedFilter{
+		Replacements:    p.Replacements,
+		Strict:          p.Strict,
+		RawSecretData:   p.RawSecretData,
+		extract:         true,
+		extractionFiles: files,
+	}
+	err := m.ApplyFilter(filter)
+	p.results = filter.results
+	if err != nil {
+		return fmt.Errorf("while extracting replacement values: %w", err)
+	}
+	// all the files are serialized before writing any of them
+	var modified []*extractionFile
+	var contents [][]byte
+	for _, replacementFiles := range files {
+		for _, file := range replacementFiles {
+			if !file.modified || slices.Contains(modified, file) {
+				continue
+			}
+			content, serializeErr := file.serialize()
+			if serializeErr != nil {
+				return serializeErr
+			}
+			modified = append(modified, file)
+			contents = append(contents, content)
+		}
+	}
+	for i, file := range modified {
+		if err = file.save(contents[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
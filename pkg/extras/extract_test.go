@@ -0,0 +1,280 @@
+package extras_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+
+	"github.com/karmafun/karmafun/pkg/extras"
+)
+
+func TestReplacementExtract(t *testing.T) {
+	t.Parallel()
+	const properties = `# platform values
+apiVersion: config.karmafun.dev/v1alpha1
+kind: PlatformValues
+metadata:
+  name: values
+data:
+  # revision deployed by the applications
+  targetRevision: deploy/citest
+  repoURL: https://github.com/karmafun/karmafun.git
+`
+	tests := []struct {
+		name         string
+		config       string
+		wantFile     string
+		wantStream   string
+		wantWarning  string
+		wantErr      string
+		wantExtracts int
+	}{
+		{
+			name: "extract from an extended path",
+			config: `
+direction: extract
+source: %s
+replacements:
+  - source: {kind: PlatformValues, fieldPath: data.targetRevision}
+    targets:
+      - select: {kind: Application}
+        fieldPaths: [spec.source.targetRevision, spec.source.helm.values.!!yaml.common.targetRevision]
+`,
+			wantFile:     strings.Replace(properties, "targetRevision: deploy/citest", "targetRevision: main", 1),
+			wantExtracts: 1,
+		},
+		{
+			name: "unchanged value",
+			config: `
+direction: extract
+source: %s
+replacements:
+  - source: {kind: PlatformValues, fieldPath: data.repoURL}
+    targets:
+      - select: {kind: ConfigMap}
+        fieldPaths: [data.repoURL]
+`,
+			wantFile: properties,
+		},
+		{
+			name: "targets disagree",
+			config: `
+direction: extract
+source: %s
+replacements:
+  - source: {kind: PlatformValues, fieldPath: data.repoURL}
+    targets:
+      - select: {kind: ConfigMap}
+        fieldPaths: [data.repoURL]
+      - select: {kind: Application}
+        fieldPaths:
+          - spec.source.helm.parameters.[name=common.repoURL].value
+`,
+			wantFile:    properties,
+			wantWarning: `"https://github.com/upstream/app.git" in Application`,
+		},
+		{
+			name: "targets disagree in strict mode",
+			config: `
+direction: extract
+strict: true
+source: %s
+replacements:
+  - source: {kind: PlatformValues, fieldPath: data.repoURL}
+    targets:
+      - select: {kind: ConfigMap}
+        fieldPaths: [data.repoURL]
+      - select: {kind: Application}
+        fieldPaths: [spec.source.repoURL]
+`,
+			wantFile: properties,
+			wantErr:  "replacement 0: targets disagree",
+		},
+		{
+			name: "source in the resources",
+			config: `
+direction: extract
+replacements:
+  - source: {kind: ConfigMap, fieldPath: data.targetRevision}
+    targets:
+      - select: {kind: Application}
+        fieldPaths: [spec.source.helm.values.!!yaml.common.targetRevision]
+`,
+			wantFile:     properties,
+			wantStream:   "  targetRevision: main\n---\n",
+			wantExtracts: 1,
+		},
+		{
+			name: "virtual source",
+			config: `
+direction: extract
+source: env://HOME
+replacements:
+  - source: {kind: Environment, fieldPath: data.HOME}
+    targets:
+      - select: {kind: Application}
+`,
+			wantFile: properties,
+			wantErr:  "cannot extract values into virtual source env://HOME",
+		},
+		{
+			name: "remote source",
+			config: `
+direction: extract
+source: https://example.com/values.yaml
+replacements:
+  - source: {kind: PlatformValues, fieldPath: data.targetRevision}
+    targets:
+      - select: {kind: Application}
+`,
+			wantFile: properties,
+			wantErr:  "cannot extract values into remote file https://example.com/values.yaml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := require.New(t)
+			path := filepath.Join(t.TempDir(), "properties.yaml")
+			req.NoError(os.WriteFile(path, []byte(properties), 0o600))
+
+			config := tt.config
+			if strings.Contains(config, "%s") {
+				config = fmt.Sprintf(config, path)
+			}
+			got, results, err := runReplacementTransformer(t, config, replacementResources)
+			content, readErr := os.ReadFile(path)
+			req.NoError(readErr)
+			req.Equal(tt.wantFile, string(content))
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			if tt.wantStream != "" {
+				req.Contains(got, tt.wantStream)
+			} else {
+				req.Equal(replacementResources, got, "targets should be left untouched")
+			}
+
+			extracts := 0
+			for _, r := range results {
+				if r.Tags["extracted"] == "true" {
+					extracts++
+				}
+			}
+			req.Equal(tt.wantExtracts, extracts)
+			if tt.wantWarning != "" {
+				req.Len(results, 1)
+				req.Equal(framework.Warning, results[0].Severity)
+				req.Contains(results[0].Message, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestReplacementExtractLayers(t *testing.T) {
+	t.Parallel()
+	req := require.New(t)
+	dir := t.TempDir()
+	common := filepath.Join(dir, "00-common.yaml")
+	prod := filepath.Join(dir, "10-prod.yaml")
+	req.NoError(os.WriteFile(common, []byte(`apiVersion: config.karmafun.dev/v1alpha1
+kind: PlatformValues
+metadata:
+  name: values
+data:
+  repoURL: https://github.com/karmafun/karmafun.git
+  targetRevision: main
+`), 0o600))
+	req.NoError(os.WriteFile(prod, []byte(`apiVersion: config.karmafun.dev/v1alpha1
+kind: PlatformValues
+metadata:
+  name: values
+data:
+  targetRevision: deploy/prod
+`), 0o600))
+
+	config := fmt.Sprintf(`
+direction: extract
+source: %s/*.yaml
+replacements:
+  - source: {kind: PlatformValues, fieldPath: data.repoURL}
+    targets:
+      - select: {kind: Application}
+        fieldPaths: [spec.source.repoURL]
+  - source: {kind: PlatformValues, fieldPath: data.targetRevision}
+    targets:
+      - select: {kind: Application}
+        fieldPaths: [spec.source.targetRevision]
+`, dir)
+	_, _, err := runReplacementTransformer(t, config, replacementResources)
+	req.NoError(err)
+
+	content, err := os.ReadFile(common)
+	req.NoError(err)
+	req.Contains(string(content), "repoURL: https://github.com/upstream/app.git\n", "common layer defines repoURL")
+	req.Contains(string(content), "targetRevision: main\n", "common layer value is overridden")
+	content, err = os.ReadFile(prod)
+	req.NoError(err)
+	req.Contains(string(content), "targetRevision: main\n", "prod layer defines targetRevision")
+	req.NotContains(string(content), "repoURL")
+}
+
+func TestReplacementExtractKustomization(t *testing.T) {
+	t.Parallel()
+	req := require.New(t)
+	dir := t.TempDir()
+	req.NoError(os.WriteFile(filepath.Join(dir, "kustomization.yaml"), []byte("resources: []\n"), 0o600))
+
+	config := fmt.Sprintf(`
+direction: extract
+source: %s
+replacements:
+  - source: {kind: PlatformValues, fieldPath: data.targetRevision}
+    targets:
+      - select: {kind: Application}
+`, dir)
+	_, _, err := runReplacementTransformer(t, config, replacementResources)
+	req.ErrorContains(err, "cannot extract values into kustomization "+dir)
+}
+
+func TestReplacementExtractFileSystem(t *testing.T) {
+	t.Parallel()
+	req := require.New(t)
+	fSys := filesys.MakeFsInMemory()
+	path := filepath.Join(t.TempDir(), "properties.yaml")
+	req.NoError(fSys.WriteFile(path, []byte(`apiVersion: config.karmafun.dev/v1alpha1
+kind: PlatformValues
+metadata:
+  name: values
+data:
+  targetRevision: deploy/citest
+`)))
+
+	config := fmt.Sprintf(`
+direction: extract
+source: %s
+replacements:
+  - source: {kind: PlatformValues, fieldPath: data.targetRevision}
+    targets:
+      - select: {kind: Application}
+        fieldPaths: [spec.source.targetRevision]
+`, path)
+	p := &extras.ExtendedReplacementTransformerPlugin{FileSystem: fSys}
+	_, err := transformResources(t, p, config, replacementResources)
+	req.NoError(err)
+
+	content, err := fSys.ReadFile(path)
+	req.NoError(err)
+	req.Contains(string(content), "targetRevision: main\n")
+	_, err = os.Stat(path)
+	req.ErrorIs(err, os.ErrNotExist, "the disk should be left untouched")
+}
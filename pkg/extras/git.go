@@ -0,0 +1,82 @@
+package extras
+
+import (
+	"fmt"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// defaultRemoteName is the name of the remote used when none is specified.
+const defaultRemoteName = "origin"
+
+// gitRepository is the git repository enclosing a kustomization, along with
+// its current commit.
+type gitRepository struct {
+	repo *git.Repository
+	head *plumbing.Reference
+}
+
+// openGitRepository opens the git repository enclosing dir.
+func openGitRepository(dir string) (*gitRepository, error) {
+	repo, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, fmt.Errorf("while opening git repository: %w", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("while getting current commit: %w", err)
+	}
+	return &gitRepository{repo: repo, head: head}, nil
+}
+
+// branch returns the current branch, or an empty string if HEAD is detached.
+func (r *gitRepository) branch() string {
+	if !r.head.Name().IsBranch() {
+		return ""
+	}
+	return r.head.Name().Short()
+}
+
+// remoteURL returns the first URL of the remote named name, or of the origin
+// remote if name is empty.
+func (r *gitRepository) remoteURL(name string) (string, error) {
+	if name == "" {
+		name = defaultRemoteName
+	}
+	remote, err := r.repo.Remote(name)
+	if err != nil {
+		return "", fmt.Errorf("while getting remote %s: %w", name, err)
+	}
+	if len(remote.Config().URLs) == 0 {
+		return "", fmt.Errorf("remote %s has no URL", name)
+	}
+	return remote.Config().URLs[0], nil
+}
+
+// tag returns the name of the first tag pointing to the current commit, or
+// an empty string if there is none.
+func (r *gitRepository) tag() (string, error) {
+	tags, err := r.repo.Tags()
+	if err != nil {
+		return "", fmt.Errorf("while getting tags: %w", err)
+	}
+	var result string
+	err = tags.ForEach(func(ref *plumbing.Reference) error {
+		commitHash := ref.Hash()
+		// annotated tags point to a tag object
+		if tag, tagErr := r.repo.TagObject(commitHash); tagErr == nil {
+			commitHash = tag.Target
+		}
+		if commitHash == r.head.Hash() {
+			result = ref.Name().Short()
+			return storer.ErrStop
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("while looking for current tag: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,43 @@
+package extras_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/karmafun/karmafun/pkg/plugins"
+	"github.com/karmafun/karmafun/pkg/utils"
+)
+
+// transformResources configures p with config, applies it to the resources
+// of input and returns the resulting resources. Configuration and
+// transformation errors are returned for inspection.
+func transformResources(t *testing.T, p resmap.TransformerPlugin, config, input string) ([]*yaml.RNode, error) {
+	t.Helper()
+	helpers, err := plugins.NewPluginHelpers()
+	require.NoError(t, err, "creating plugin helpers should not error")
+	if err = p.Config(helpers, []byte(config)); err != nil {
+		return nil, fmt.Errorf("while configuring plugin: %w", err)
+	}
+
+	nodes, err := kio.FromBytes([]byte(input))
+	require.NoError(t, err, "reading input resources should not error")
+	rm := utils.ResourceMapFromNodes(nodes)
+	if err = p.Transform(rm); err != nil {
+		return nil, fmt.Errorf("while transforming resources: %w", err)
+	}
+	return rm.ToRNodeSlice(), nil
+}
+
+// resourcesString returns the serialization of nodes as a YAML stream.
+func resourcesString(t *testing.T, nodes []*yaml.RNode) string {
+	t.Helper()
+	var b bytes.Buffer
+	require.NoError(t, kio.ByteWriter{Writer: &b}.Write(nodes), "writing resources should not error")
+	return b.String()
+}
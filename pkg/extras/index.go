@@ -0,0 +1,123 @@
+package extras
+
+import (
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/resid"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// indexEntry is a resource of the index with its current and previous ids.
+type indexEntry struct {
+	node *yaml.RNode
+	ids  []resid.ResId
+}
+
+// resourceIndex indexes the resources of a filter run by kind, name and
+// labels, so that target selectors don't scan all the resources and the
+// resource ids are only computed once.
+//
+// The index only narrows down the candidates of a selector. The candidates
+// still need to be checked against the whole selector.
+type resourceIndex struct {
+	entries []indexEntry
+	byKind  map[string][]int
+	byName  map[string][]int
+	byLabel map[string][]int
+}
+
+// newResourceIndex returns the index of nodes.
+func newResourceIndex(nodes []*yaml.RNode) (*resourceIndex, error) {
+	index := &resourceIndex{
+		entries: make([]indexEntry, 0, len(nodes)),
+		byKind:  map[string][]int{},
+		byName:  map[string][]int{},
+		byLabel: map[string][]int{},
+	}
+	for i, n := range nodes {
+		ids, err := makeResIds(n)
+		if err != nil {
+			return nil, err
+		}
+		index.entries = append(index.entries, indexEntry{node: n, ids: ids})
+		kinds, names := map[string]bool{}, map[string]bool{}
+		for _, id := range ids {
+			if !kinds[id.Kind] {
+				kinds[id.Kind] = true
+				index.byKind[id.Kind] = append(index.byKind[id.Kind], i)
+			}
+			if !names[id.Name] {
+				names[id.Name] = true
+				index.byName[id.Name] = append(index.byName[id.Name], i)
+			}
+		}
+		for key, value := range n.GetLabels() {
+			label := key + "=" + value
+			index.byLabel[label] = append(index.byLabel[label], i)
+		}
+	}
+	return index, nil
+}
+
+// equalityLabels returns the key=value requirements of the label selector.
+// It returns nil if the selector contains set based requirements, as they are
+// not indexed.
+func equalityLabels(selector string) []string {
+	if selector == "" || strings.ContainsAny(selector, "()") {
+		return nil
+	}
+	var result []string
+	for _, requirement := range strings.Split(selector, ",") {
+		key, value, found := strings.Cut(requirement, "=")
+		if !found || strings.HasSuffix(key, "!") {
+			continue
+		}
+		value = strings.TrimPrefix(value, "=")
+		result = append(result, strings.TrimSpace(key)+"="+strings.TrimSpace(value))
+	}
+	return result
+}
+
+// candidates returns the indexes, in order, of the entries that may be
+// selected by id and labelSelector. It returns the smallest of the kind, name
+// and label buckets of the selector, or all the entries if the selector has
+// none of them.
+func (x *resourceIndex) candidates(id resid.ResId, labelSelector string) []int {
+	var buckets [][]int
+	if id.Kind != "" {
+		buckets = append(buckets, x.byKind[id.Kind])
+	}
+	if id.Name != "" {
+		buckets = append(buckets, x.byName[id.Name])
+	}
+	for _, label := range equalityLabels(labelSelector) {
+		buckets = append(buckets, x.byLabel[label])
+	}
+	if len(buckets) == 0 {
+		all := make([]int, len(x.entries))
+		for i := range all {
+			all[i] = i
+		}
+		return all
+	}
+	result := buckets[0]
+	for _, bucket := range buckets[1:] {
+		if len(bucket) < len(result) {
+			result = bucket
+		}
+	}
+	return result
+}
+
+// isIdentityPath returns true if writing at path may change the ids or labels
+// of a resource, and thus invalidates the index.
+func isIdentityPath(path []string) bool {
+	if len(path) == 0 {
+		return true
+	}
+	switch path[0] {
+	case yaml.MetadataField, yaml.KindField, yaml.APIVersionField:
+		return true
+	}
+	return false
+}
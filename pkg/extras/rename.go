@@ -0,0 +1,106 @@
+package extras
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// KeyExtender is implemented by extenders allowing the renaming of mapping
+// keys.
+type KeyExtender interface {
+	// MoveKey moves the field at path to newPath.
+	MoveKey(path, newPath []string) error
+}
+
+// moveKey moves the field at path in node to newPath in node. The key is
+// renamed with the last element of newPath and the missing mappings of newPath
+// are created. The value of the field, including its subtree and comments, is
+// kept.
+//
+// If there is no field at path, an error wrapping [ErrNoMatch] is returned.
+// Moving a field to a key that already exists in the mapping is an error.
+func moveKey(node *yaml.RNode, path, newPath []string) error {
+	if len(path) == 0 {
+		return fmt.Errorf("cannot rename the key of the root node")
+	}
+	parent, err := Lookup(node, path[:len(path)-1], 0)
+	if err != nil {
+		return err
+	}
+	if parent == nil {
+		return fmt.Errorf("%w: %s", ErrNoMatch, strings.Join(path, "."))
+	}
+	if err = moveField(parent, path[len(path)-1], node, newPath); err != nil {
+		return fmt.Errorf("cannot rename key %s: %w", strings.Join(path, "."), err)
+	}
+	return nil
+}
+
+// moveField moves the field name of the parent mapping to newPath in root.
+// The field stays in place if it is only renamed. Otherwise it is removed
+// from parent and appended to the mapping at newPath, which is created if
+// needed. parent is kept even if it becomes empty.
+func moveField(parent *yaml.RNode, name string, root *yaml.RNode, newPath []string) error {
+	if isSequencePathElement(name) {
+		return fmt.Errorf("cannot rename the sequence element %s", name)
+	}
+	if len(newPath) == 0 || isSequencePathElement(newPath[len(newPath)-1]) {
+		return fmt.Errorf("invalid new key path %s", strings.Join(newPath, "."))
+	}
+	if parent.YNode().Kind != yaml.MappingNode {
+		return fmt.Errorf("parent is not a mapping")
+	}
+	content := parent.YNode().Content
+	index := -1
+	for i := 0; i+1 < len(content); i += 2 {
+		if content[i].Value == name {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return fmt.Errorf("%w: %s", ErrNoMatch, name)
+	}
+	key, value := content[index], content[index+1]
+	newKey := newPath[len(newPath)-1]
+	newParent, err := Lookup(root, newPath[:len(newPath)-1], yaml.MappingNode)
+	if err != nil {
+		return err
+	}
+	if newParent.YNode().Kind != yaml.MappingNode {
+		return fmt.Errorf("new parent %s is not a mapping", strings.Join(newPath[:len(newPath)-1], "."))
+	}
+	if containsNode(value, newParent.YNode()) {
+		return fmt.Errorf("cannot move the field into itself")
+	}
+	if newParent.YNode() == parent.YNode() && name == newKey {
+		return nil
+	}
+	if newParent.Field(newKey) != nil {
+		return fmt.Errorf("key %s already exists", newKey)
+	}
+	key.Value = newKey
+	if newParent.YNode() == parent.YNode() {
+		return nil
+	}
+	parent.YNode().Content = slices.Delete(content, index, index+2)
+	newParent.YNode().Content = append(newParent.YNode().Content, key, value)
+	return nil
+}
+
+// isSequencePathElement returns true if element designates sequence items
+// rather than a mapping key.
+func isSequencePathElement(element string) bool {
+	return yaml.IsListIndex(element) || element == appendPathElement || element == prependPathElement
+}
+
+// containsNode returns true if node is n or one of its descendants.
+func containsNode(n, node *yaml.Node) bool {
+	if n == node {
+		return true
+	}
+	return slices.ContainsFunc(n.Content, func(child *yaml.Node) bool { return containsNode(child, node) })
+}
@@ -0,0 +1,197 @@
+package extras_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const renameResources = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: names
+data:
+  newKey: route
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-config
+  labels:
+    app: demo
+data:
+  # legacy key
+  old.key: value
+  config.json: |
+    {
+      "legacy": {
+        "a": 1
+      },
+      "other": 2
+    }
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: app
+spec:
+  source:
+    helm:
+      values: |
+        ingress:
+          # host configuration
+          hosts:
+          - example.com
+        other: true
+`
+
+func TestReplacementRenameKey(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		source  string
+		target  string
+		path    string
+		extra   string
+		strict  bool
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "resource key with comment",
+			source: "sourceValue: new.key",
+			target: "app-config",
+			path:   "data.old\\.key",
+			want:   "  # legacy key\n  new.key: value\n",
+		},
+		{
+			name:   "label key",
+			source: "sourceValue: app.kubernetes.io/name",
+			target: "app-config",
+			path:   "metadata.labels.app",
+			want:   "  labels:\n    app.kubernetes.io/name: demo\n",
+		},
+		{
+			name:   "yaml key with subtree",
+			source: "source: {kind: ConfigMap, name: names, fieldPath: data.newKey}",
+			target: "app",
+			path:   "spec.source.helm.values.!!yaml.ingress",
+			want:   "        route:\n          # host configuration\n          hosts:\n          - example.com\n",
+		},
+		{
+			name:   "json key",
+			source: "sourceValue: modern",
+			target: "app-config",
+			path:   "data.config\\.json.!!json.legacy",
+			want:   "      \"modern\": {\n        \"a\": 1\n      },\n",
+		},
+		{
+			name:   "missing key",
+			source: "sourceValue: new.key",
+			target: "app-config",
+			path:   "data.missing",
+			want:   "  old.key: value\n",
+		},
+		{
+			name:    "strict missing key",
+			source:  "sourceValue: new.key",
+			target:  "app-config",
+			path:    "data.missing",
+			strict:  true,
+			wantErr: "matches nothing",
+		},
+		{
+			name:    "strict missing key in extended path",
+			source:  "sourceValue: route",
+			target:  "app",
+			path:    "spec.source.helm.values.!!yaml.missing",
+			strict:  true,
+			wantErr: "path matches nothing",
+		},
+		{
+			name:    "existing key",
+			source:  "sourceValue: config.json",
+			target:  "app-config",
+			path:    "data.old\\.key",
+			wantErr: "key config.json already exists",
+		},
+		{
+			name:   "condition",
+			source: "sourceValue: new.key",
+			target: "app-config",
+			path:   "data.old\\.key",
+			extra:  "when: {equals: other}",
+			want:   "  old.key: value\n",
+		},
+		{
+			name:   "resource key to another parent",
+			source: "sourceValue: metadata.annotations.legacy",
+			target: "app-config",
+			path:   "data.old\\.key",
+			extra:  "options: {renameKey: true, keyPath: true}",
+			want:   "  annotations:\n    # legacy key\n    legacy: value\ndata:\n  config.json: |\n",
+		},
+		{
+			name:   "yaml subtree to another parent",
+			source: "sourceValue: hosts",
+			target: "app",
+			path:   "spec.source.helm.values.!!yaml.ingress.hosts",
+			extra:  "options: {renameKey: true, keyPath: true}",
+			want:   "        ingress: {}\n        other: true\n        # host configuration\n        hosts:\n",
+		},
+		{
+			name:    "yaml subtree into itself",
+			source:  "sourceValue: ingress.nested",
+			target:  "app",
+			path:    "spec.source.helm.values.!!yaml.ingress",
+			extra:   "options: {renameKey: true, keyPath: true}",
+			wantErr: "cannot move the field into itself",
+		},
+		{
+			name:    "key path without renameKey",
+			source:  "sourceValue: new.key",
+			target:  "app-config",
+			path:    "data.old\\.key",
+			extra:   "options: {keyPath: true}",
+			wantErr: "keyPath option can only be used with renameKey",
+		},
+		{
+			name:    "create",
+			source:  "sourceValue: new.key",
+			target:  "app-config",
+			path:    "data.old\\.key",
+			extra:   "options: {renameKey: true, create: true}",
+			wantErr: "cannot be used with create",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := require.New(t)
+			extra := tt.extra
+			if !strings.HasPrefix(extra, "options:") {
+				extra = "options: {renameKey: true}\n        " + extra
+			}
+			config := fmt.Sprintf(`
+strict: %t
+replacements:
+  - %s
+    targets:
+      - select:
+          name: %s
+        fieldPaths:
+          - %s
+        %s
+`, tt.strict, tt.source, tt.target, tt.path, extra)
+			got, _, err := runReplacementTransformer(t, config, renameResources)
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			req.Contains(got, tt.want)
+		})
+	}
+}
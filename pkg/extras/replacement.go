@@ -1,18 +1,23 @@
 // Copyright 2021 The Kubernetes Authors.
 // SPDX-License-Identifier: Apache-2.0
 
-// cSpell: words filesys
+// cSpell: words filesys kioutil
 package extras
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
 
 	"sigs.k8s.io/kustomize/api/resmap"
 	"sigs.k8s.io/kustomize/api/resource"
 	"sigs.k8s.io/kustomize/api/types"
 	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
 	"sigs.k8s.io/kustomize/kyaml/resid"
 	kyaml_utils "sigs.k8s.io/kustomize/kyaml/utils"
 	"sigs.k8s.io/kustomize/kyaml/yaml"
@@ -21,42 +26,211 @@ import (
 )
 
 type extendedFilter struct {
-	Replacements []types.Replacement `json:"replacements,omitempty" yaml:"replacements,omitempty"`
-	sourceNodes  []*yaml.RNode
+	Replacements []Replacement `json:"replacements,omitempty" yaml:"replacements,omitempty"`
+	// Strict makes the filter fail when a target or a field path matches
+	// nothing.
+	Strict bool `json:"strict,omitempty" yaml:"strict,omitempty"`
+	// RawSecretData disables the base64 decoding and encoding of the Secret
+	// data and ConfigMap binaryData fields.
+	RawSecretData bool `json:"rawSecretData,omitempty" yaml:"rawSecretData,omitempty"`
+	sources       *sourceLayers
+	// replacementSources contains the source resources specific to each
+	// replacement. A nil entry means that the replacement uses sources.
+	replacementSources []*sourceLayers
+	writes             map[fieldKey]fieldWrite
+	results            framework.Results
+	// debug makes the filter explain the selection of the targets in its
+	// results.
+	debug bool
+	// index indexes the filtered resources. It is nil until needed and after
+	// a replacement modifies the identity of a resource.
+	index *resourceIndex
+	// documents keeps the documents embedded in the target fields until the
+	// end of the run.
+	documents *documentCache
+	// extract reverses the replacements, copying the values of the targets
+	// back to their source.
+	extract bool
+	// extractionFiles contains the resource files receiving the extracted
+	// values of each replacement. A nil entry means that the source of the
+	// replacement is selected in the filtered resources.
+	extractionFiles [][]*extractionFile
+}
+
+// fieldKey identifies a field written by a replacement. As extended paths
+// write inside a scalar node, the extended part of the path is part of the key.
+type fieldKey struct {
+	node      *yaml.Node
+	extension string
+}
+
+// fieldWrite records the replacement that last wrote a field.
+type fieldWrite struct {
+	value       string
+	replacement int
 }
 
 // Filter replaces values of targets with values from sources.
-func (f extendedFilter) Filter(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
-	sourceNodes := f.sourceNodes
-	if len(sourceNodes) == 0 {
-		sourceNodes = nodes
+//
+// The replacements are applied to copies of nodes. The copies are committed
+// into nodes only when all the replacements succeed, so a failing replacement
+// leaves nodes untouched. The returned error contains the errors of all the
+// failing replacements.
+//
+// When extract is set, the values of the targets are copied back to the
+// sources instead.
+func (f *extendedFilter) Filter(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+	f.writes = map[fieldKey]fieldWrite{}
+	f.index = nil
+	f.documents = newDocumentCache()
+	working := make([]*yaml.RNode, len(nodes))
+	for i, n := range nodes {
+		working[i] = n.Copy()
 	}
-	for i, r := range f.Replacements {
-		if r.Source == nil || r.Targets == nil {
-			return nil, fmt.Errorf("replacements must specify a source and at least one target")
+	var err error
+	if f.extract {
+		err = f.extractReplacements(working)
+	} else {
+		err = f.applyReplacements(working)
+	}
+	if flushErr := f.documents.flush(); flushErr != nil {
+		err = errors.Join(err, fmt.Errorf("while saving embedded documents: %w", flushErr))
+	}
+	if err != nil {
+		f.dropReport()
+		return nil, err
+	}
+	// The nodes are updated in place, as the resource map identifies its
+	// resources by their node.
+	for i, n := range nodes {
+		n.SetYNode(working[i].YNode())
+	}
+	return nodes, nil
+}
+
+// dropReport removes from the filter results the entries reporting field
+// writes and their conflicts, as they have not been committed.
+func (f *extendedFilter) dropReport() {
+	var results framework.Results
+	for _, r := range f.results {
+		_, written := r.Tags["replacement"]
+		_, conflicting := r.Tags["conflict"]
+		if !written && !conflicting {
+			results = append(results, r)
 		}
-		value, err := getReplacement(sourceNodes, &f.Replacements[i])
+	}
+	f.results = results
+}
+
+// resourceIndex returns the index of nodes, building it if needed.
+func (f *extendedFilter) resourceIndex(nodes []*yaml.RNode) (*resourceIndex, error) {
+	if f.index == nil {
+		index, err := newResourceIndex(nodes)
 		if err != nil {
 			return nil, err
 		}
-		nodes, err = applyReplacement(nodes, value, r.Targets)
+		f.index = index
+	}
+	return f.index, nil
+}
+
+// applyReplacements applies the replacements in order to nodes. A failing
+// replacement doesn't stop the following ones, and the returned error joins
+// the errors of all the failing replacements.
+func (f *extendedFilter) applyReplacements(nodes []*yaml.RNode) error {
+	var errs []error
+	for i, r := range f.Replacements {
+		if err := f.applyReplacementAt(nodes, r, i); err != nil {
+			errs = append(errs, fmt.Errorf("replacement %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// applyReplacementAt applies the replacement r, at index i, to nodes.
+func (f *extendedFilter) applyReplacementAt(nodes []*yaml.RNode, r Replacement, i int) error {
+	if (r.Source == nil && r.SourceValue == nil) || r.Targets == nil {
+		return fmt.Errorf("replacements must specify a source and at least one target")
+	}
+	if r.Source != nil && r.SourceValue != nil {
+		return fmt.Errorf("source and sourceValue are mutually exclusive")
+	}
+	// a correlated source is selected for each target
+	var value *yaml.RNode
+	var err error
+	if r.Source == nil || !r.Source.isCorrelated() {
+		value, err = f.getValue(nodes, i, r.Source)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
-	return nodes, nil
+	return f.applyReplacement(nodes, value, i)
 }
 
-func getReplacement(nodes []*yaml.RNode, r *types.Replacement) (*yaml.RNode, error) {
-	source, err := selectSourceNode(nodes, r.Source)
+// getValue returns the value of the replacement at index replacement, either
+// its static sourceValue or the value selected by source.
+func (f *extendedFilter) getValue(
+	nodes []*yaml.RNode,
+	replacement int,
+	source *SourceSelector,
+) (*yaml.RNode, error) {
+	if value := f.Replacements[replacement].SourceValue; value != nil {
+		return yaml.NewStringRNode(*value), nil
+	}
+	layers := f.sources
+	if replacement < len(f.replacementSources) && f.replacementSources[replacement] != nil {
+		layers = f.replacementSources[replacement]
+	}
+	if layers != nil && len(layers.nodes) > 0 {
+		return f.getReplacement(layers.nodes, nil, layers, replacement, source)
+	}
+	index, err := f.resourceIndex(nodes)
 	if err != nil {
 		return nil, err
 	}
+	return f.getReplacement(nodes, index, layers, replacement, source)
+}
 
-	if r.Source.FieldPath == "" {
-		r.Source.FieldPath = types.DefaultReplacementFieldPath
+// correlatedValue returns the value of the replacement at index replacement
+// for target when the replacement source references the target.
+func (f *extendedFilter) correlatedValue(
+	nodes []*yaml.RNode,
+	replacement int,
+	target *yaml.RNode,
+) (*yaml.RNode, error) {
+	if err := f.documents.flushResource(target); err != nil {
+		return nil, err
+	}
+	source, err := f.Replacements[replacement].Source.resolve(target)
+	if err != nil {
+		return nil, fmt.Errorf("while resolving source for %s: %w", resid.FromRNode(target), err)
+	}
+	return f.getValue(nodes, replacement, source)
+}
+
+// getReplacement returns the value selected by selector for the replacement
+// at index replacement. index is the index of nodes, if any. When the source
+// resources come from several layers, it reports the layers the value comes
+// from.
+func (f *extendedFilter) getReplacement(
+	nodes []*yaml.RNode,
+	index *resourceIndex,
+	layers *sourceLayers,
+	replacement int,
+	selector *SourceSelector,
+) (*yaml.RNode, error) {
+	source, err := selectSourceNode(nodes, index, selector)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.documents.flushResource(source); err != nil {
+		return nil, err
 	}
-	fieldPath := kyaml_utils.SmarterPathSplitter(r.Source.FieldPath, ".")
+
+	if selector.FieldPath == "" {
+		selector.FieldPath = types.DefaultReplacementFieldPath
+	}
+	fieldPath := kyaml_utils.SmarterPathSplitter(selector.FieldPath, ".")
 
 	rn, err := source.Pipe(yaml.Lookup(fieldPath...))
 	if err != nil {
@@ -65,22 +239,73 @@ func getReplacement(nodes []*yaml.RNode, r *types.Replacement) (*yaml.RNode, err
 	if rn.IsNilOrEmpty() {
 		return nil, fmt.Errorf(
 			"fieldPath `%s` is missing for replacement source %s",
-			r.Source.FieldPath,
-			r.Source.ResId,
+			selector.FieldPath,
+			selector.ResId,
 		)
 	}
+	if origins := layers.originsOf(rn.YNode()); len(origins) > 0 {
+		var current any = yaml.GetValue(rn)
+		if isSecret(source) {
+			current = redactedValue
+		}
+		f.results = append(f.results, &framework.Result{
+			Message: fmt.Sprintf("source of replacement %d comes from %s",
+				replacement, strings.Join(origins, ", ")),
+			Severity:    framework.Info,
+			ResourceRef: resourceRef(source),
+			Field:       &framework.Field{Path: selector.FieldPath, CurrentValue: current},
+			File:        &framework.File{Path: origins[len(origins)-1]},
+		})
+	}
+	if !f.RawSecretData && isBase64Field(source, fieldPath) {
+		rn, err = decodeBase64Value(rn)
+		if err != nil {
+			return nil, fmt.Errorf("while decoding replacement source %s: %w", selector.FieldPath, err)
+		}
+	}
 
-	return getRefinedValue(r.Source.Options, rn)
+	return getRefinedValue(selector.Options, rn)
 }
 
+// errNothingSelected is returned when a source selector matches no resource.
+var errNothingSelected = errors.New("nothing selected")
+
 // selectSourceNode finds the node that matches the selector, returning
-// an error if multiple or none are found.
-func selectSourceNode(nodes []*yaml.RNode, selector *types.SourceSelector) (*yaml.RNode, error) {
+// an error if multiple or none are found. When index is not nil, it is the
+// index of nodes and only its candidates are considered.
+func selectSourceNode(nodes []*yaml.RNode, index *resourceIndex, selector *SourceSelector) (*yaml.RNode, error) {
+	var entries []indexEntry
+	if index != nil {
+		for _, i := range index.candidates(selector.ResId, selector.LabelSelector) {
+			entries = append(entries, index.entries[i])
+		}
+	} else {
+		for _, n := range nodes {
+			entries = append(entries, indexEntry{node: n})
+		}
+	}
 	var matches []*yaml.RNode
-	for _, n := range nodes {
-		ids, err := makeResIds(n)
-		if err != nil {
-			return nil, fmt.Errorf("error getting node IDs: %w", err)
+	for _, entry := range entries {
+		n := entry.node
+		if selector.LabelSelector != "" || selector.AnnotationSelector != "" {
+			selected, err := matchesAnnoAndLabelSelector(n, &types.Selector{
+				LabelSelector:      selector.LabelSelector,
+				AnnotationSelector: selector.AnnotationSelector,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if !selected {
+				continue
+			}
+		}
+		ids := entry.ids
+		if ids == nil {
+			var err error
+			ids, err = makeResIds(n)
+			if err != nil {
+				return nil, fmt.Errorf("error getting node IDs: %w", err)
+			}
 		}
 		for _, id := range ids {
 			if id.IsSelectedBy(selector.ResId) {
@@ -94,7 +319,7 @@ func selectSourceNode(nodes []*yaml.RNode, selector *types.SourceSelector) (*yam
 		}
 	}
 	if len(matches) == 0 {
-		return nil, fmt.Errorf("nothing selected by %s", selector)
+		return nil, fmt.Errorf("%w by %s", errNothingSelected, selector)
 	}
 	return matches[0], nil
 }
@@ -124,59 +349,189 @@ func getRefinedValue(options *types.FieldOptions, rn *yaml.RNode) (*yaml.RNode,
 	return n, nil
 }
 
-func applyReplacement(
+func (f *extendedFilter) applyReplacement(
 	nodes []*yaml.RNode,
 	value *yaml.RNode,
-	targetSelectors []*types.TargetSelector,
-) ([]*yaml.RNode, error) {
-	for _, selector := range targetSelectors {
+	replacement int,
+) error {
+	for target, selector := range f.Replacements[replacement].Targets {
 		if selector.Select == nil {
-			return nil, fmt.Errorf("target must specify resources to select")
+			return fmt.Errorf("target must specify resources to select")
 		}
 		if len(selector.FieldPaths) == 0 {
 			selector.FieldPaths = []string{types.DefaultReplacementFieldPath}
 		}
-		for _, possibleTarget := range nodes {
-			ids, err := makeResIds(possibleTarget)
-			if err != nil {
-				return nil, err
+		strict := selector.isStrict(f.Strict)
+		selected := false
+		skipped, unsourced := 0, 0
+		index, err := f.resourceIndex(nodes)
+		if err != nil {
+			return err
+		}
+		candidates := index.candidates(selector.Select.ResId, selector.Select.LabelSelector)
+		if f.debug {
+			// explain the selection of all the resources
+			candidates = index.candidates(resid.ResId{}, "")
+		}
+		for _, candidate := range candidates {
+			possibleTarget, ids := index.entries[candidate].node, index.entries[candidate].ids
+			if selector.readsContent() {
+				if err = f.documents.flushResource(possibleTarget); err != nil {
+					return err
+				}
+			}
+
+			if f.debug {
+				if err = f.explainSelection(possibleTarget, ids, selector, replacement, target); err != nil {
+					return err
+				}
 			}
 
 			// filter targets by label and annotation selectors
 			selectByAnnoAndLabel, err := selectByAnnoAndLabel(possibleTarget, selector)
 			if err != nil {
-				return nil, err
+				return err
 			}
 			if !selectByAnnoAndLabel {
 				continue
 			}
 
 			// filter targets by matching resource IDs
-			for i, id := range ids {
-				if id.IsSelectedBy(selector.Select.ResId) && !rejectId(selector.Reject, &ids[i]) {
-					err := copyValueToTarget(possibleTarget, value, selector)
-					if err != nil {
-						return nil, err
-					}
-					break
+			if !isSelectedId(ids, selector) {
+				continue
+			}
+			selected = true
+			targetValue := value
+			if targetValue == nil {
+				targetValue, err = f.correlatedValue(nodes, replacement, possibleTarget)
+				if !strict && (errors.Is(err, errNothingSelected) || errors.Is(err, ErrNoMatch)) {
+					unsourced++
+					continue
+				}
+				if err != nil {
+					return err
 				}
 			}
+			skippedFields, err := f.copyValueToTarget(possibleTarget, targetValue, selector, replacement, target)
+			if err != nil {
+				return err
+			}
+			skipped += skippedFields
+		}
+		if strict && !selected {
+			return fmt.Errorf("target selector %s matches no resource", selector.Select)
+		}
+		if skipped > 0 {
+			f.results = append(f.results, &framework.Result{
+				Message: fmt.Sprintf("replacement %d target %d skipped %d field(s) not satisfying its condition",
+					replacement, target, skipped),
+				Severity: framework.Info,
+			})
+		}
+		if unsourced > 0 {
+			f.results = append(f.results, &framework.Result{
+				Message: fmt.Sprintf("replacement %d target %d skipped %d resource(s) without a matching source",
+					replacement, target, unsourced),
+				Severity: framework.Info,
+			})
 		}
 	}
-	return nodes, nil
+	return nil
+}
+
+// trace adds a debug message about the resource n to the filter results when
+// the debug mode is enabled.
+func (f *extendedFilter) trace(n *yaml.RNode, format string, args ...any) {
+	if !f.debug {
+		return
+	}
+	f.results = append(f.results, &framework.Result{
+		Message:     "debug: " + fmt.Sprintf(format, args...),
+		Severity:    framework.Info,
+		ResourceRef: resourceRef(n),
+		Tags:        map[string]string{"debug": "true"},
+	})
 }
 
-func selectByAnnoAndLabel(n *yaml.RNode, t *types.TargetSelector) (bool, error) {
-	if matchesSelect, err := matchesAnnoAndLabelSelector(n, t.Select); !matchesSelect || err != nil {
+// explainSelection traces why the resource n, with its current and previous
+// ids, is selected or not by the target selector. It follows the same steps
+// as [extendedFilter.applyReplacement].
+func (f *extendedFilter) explainSelection(
+	n *yaml.RNode,
+	ids []resid.ResId,
+	selector *TargetSelector,
+	replacement, target int,
+) error {
+	prefix := fmt.Sprintf("replacement %d target %d: %s", replacement, target, ids[0])
+	if len(ids) > 1 {
+		previous := make([]string, 0, len(ids)-1)
+		for _, id := range ids[1:] {
+			previous = append(previous, id.String())
+		}
+		f.trace(n, "%s has previous ids %s", prefix, strings.Join(previous, ", "))
+	}
+
+	reason, err := selector.Select.explainContent(n)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		f.trace(n, "%s is not selected: %s", prefix, reason)
+		return nil
+	}
+	for i, reject := range selector.Reject {
+		if !reject.hasContentSelectors() {
+			continue
+		}
+		reason, err = reject.explainContent(n)
+		if err != nil {
+			return fmt.Errorf("while matching reject selector: %w", err)
+		}
+		if reason == "" {
+			f.trace(n, "%s is rejected by the content of reject selector %d (%s)", prefix, i, reject)
+			return nil
+		}
+	}
+
+	for i, id := range ids {
+		kind := "id"
+		if i > 0 {
+			kind = "previous id"
+		}
+		if !id.IsSelectedBy(selector.Select.ResId) {
+			f.trace(n, "%s %s %s doesn't match select %s", prefix, kind, id, selector.Select.ResId)
+			continue
+		}
+		for j, reject := range selector.Reject {
+			if !reject.IsEmpty() && id.IsSelectedBy(reject.ResId) {
+				f.trace(n, "%s %s %s is rejected by reject selector %d (%s)", prefix, kind, id, j, reject.ResId)
+				break
+			}
+		}
+		if !rejectId(selector.Reject, &ids[i]) {
+			f.trace(n, "%s is selected by its %s %s", prefix, kind, id)
+			return nil
+		}
+	}
+	f.trace(n, "%s is not selected: no id matches", prefix)
+	return nil
+}
+
+func selectByAnnoAndLabel(n *yaml.RNode, t *TargetSelector) (bool, error) {
+	if matchesSelect, err := t.Select.matchesContent(n); !matchesSelect || err != nil {
 		return false, err
 	}
 	for _, reject := range t.Reject {
-		if reject.AnnotationSelector == "" && reject.LabelSelector == "" {
+		if !reject.hasContentSelectors() {
 			continue
 		}
-		if m, err := matchesAnnoAndLabelSelector(n, reject); m || err != nil {
+		m, err := reject.matchesContent(n)
+		if err != nil {
 			return false, fmt.Errorf("while matching reject selector: %w", err)
 		}
+		if m {
+			return false, nil
+		}
 	}
 	return true, nil
 }
@@ -194,7 +549,18 @@ func matchesAnnoAndLabelSelector(n *yaml.RNode, selector *types.Selector) (bool,
 	return annoMatch && labelMatch, nil
 }
 
-func rejectId(rejects []*types.Selector, id *resid.ResId) bool {
+// isSelectedId returns true if one of ids is selected by the target selector
+// and not rejected by its reject selectors.
+func isSelectedId(ids []resid.ResId, selector *TargetSelector) bool {
+	for i, id := range ids {
+		if id.IsSelectedBy(selector.Select.ResId) && !rejectId(selector.Reject, &ids[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func rejectId(rejects []*Selector, id *resid.ResId) bool {
 	for _, r := range rejects {
 		if !r.IsEmpty() && id.IsSelectedBy(r.ResId) {
 			return true
@@ -203,48 +569,470 @@ func rejectId(rejects []*types.Selector, id *resid.ResId) bool {
 	return false
 }
 
-func copyValueToTarget(target, value *yaml.RNode, selector *types.TargetSelector) error {
+// copyValueToTarget copies value to the fields of target designated by
+// selector. It returns the number of fields skipped because their current
+// value doesn't satisfy the selector condition.
+func (f *extendedFilter) copyValueToTarget(
+	target, value *yaml.RNode,
+	selector *TargetSelector,
+	replacement, targetIndex int,
+) (int, error) {
+	strict := selector.isStrict(f.Strict)
+	condition, err := selector.When.compile()
+	if err != nil {
+		return 0, err
+	}
+	skipped := 0
 	for _, fp := range selector.FieldPaths {
 		fieldPath := kyaml_utils.SmarterPathSplitter(fp, ".")
 		extendedPath, err := NewExtendedPath(fieldPath)
 		if err != nil {
-			return err
+			return skipped, err
+		}
+		renameKey := selector.Options.isRenameKey()
+		if !renameKey && selector.Options != nil && selector.Options.KeyPath {
+			return skipped, fmt.Errorf("keyPath option can only be used with renameKey")
+		}
+		if !f.RawSecretData && !selector.Options.isRaw() && isBase64Field(target, extendedPath.ResourcePath) &&
+			(!renameKey || extendedPath.HasExtensions()) {
+			if !extendedPath.HasExtensions() && f.isBase64Source(replacement) {
+				return skipped, fmt.Errorf("source value is base64 encoded by its encoding option and %s is "+
+					"base64 encoded automatically, remove the source encoding or set the raw target option", fp)
+			}
+			extendedPath.DecodeBase64()
 		}
 		create, err := shouldCreateField(selector.Options, extendedPath.ResourcePath)
 		if err != nil {
-			return err
+			return skipped, err
+		}
+		sequence, err := selector.Options.sequenceOptions()
+		if err != nil {
+			return skipped, err
+		}
+		createKind := value.YNode().Kind
+		if sequence != nil && !extendedPath.HasExtensions() {
+			createKind = yaml.SequenceNode
+		}
+		extendedPath.Sequence = sequence
+		extendedPath.Typed = selector.Options != nil && selector.Options.Type != ""
+		extendedPath.KeyPath = renameKey && selector.Options.KeyPath
+		extendedPath.documents = f.documents
+		extendedPath.resource = target.YNode()
+		if isIdentityPath(extendedPath.ResourcePath) {
+			// the ids and labels of target may change
+			f.index = nil
+		}
+
+		if renameKey {
+			if create || sequence != nil || selector.Options.Delimiter != "" {
+				return skipped, fmt.Errorf("renameKey option cannot be used with create, delimiter or sequence insertion")
+			}
+			if !extendedPath.HasExtensions() {
+				// conditions read the renamed fields as a whole
+				if err = f.documents.flushResource(target); err != nil {
+					return skipped, err
+				}
+			}
+			renamed, skippedFields, renameErr := renameTargetKeys(target, value, extendedPath, condition)
+			skipped += skippedFields
+			if renameErr != nil && (strict || !errors.Is(renameErr, ErrNoMatch)) {
+				return skipped, fmt.Errorf("while renaming key at field path %s in %s: %w",
+					fp, resid.FromRNode(target), renameErr)
+			}
+			if strict && renamed == 0 && skippedFields == 0 {
+				return skipped, fmt.Errorf("field path %s matches nothing in %s", fp, resid.FromRNode(target))
+			}
+			if renamed > 0 {
+				f.reportRename(target, value, extendedPath, replacement, targetIndex, renamed)
+			}
+			continue
 		}
 
 		var targetFields []*yaml.RNode
+		created := false
 		if create {
-			createdField, createErr := target.Pipe(yaml.LookupCreate(value.YNode().Kind, extendedPath.ResourcePath...))
+			existing, lookupErr := target.Pipe(yaml.Lookup(extendedPath.ResourcePath...))
+			if lookupErr != nil {
+				return skipped, fmt.Errorf("error finding field in replacement target: %w", lookupErr)
+			}
+			created = yaml.IsMissingOrNull(existing)
+			if !created && !extendedPath.HasExtensions() {
+				if err = f.documents.flushField(existing.YNode()); err != nil {
+					return skipped, err
+				}
+			}
+			// the condition is checked before creating the field
+			if condition != nil {
+				holds, conditionErr := conditionHolds(condition, existing, extendedPath)
+				if conditionErr != nil {
+					return skipped, fmt.Errorf("while evaluating condition on field path %s in %s: %w",
+						fp, resid.FromRNode(target), conditionErr)
+				}
+				if !holds {
+					skipped++
+					continue
+				}
+			}
+			prepareCreation(target, extendedPath.ResourcePath)
+			createdField, createErr := target.Pipe(yaml.LookupCreate(createKind, extendedPath.ResourcePath...))
 			if createErr != nil {
-				return fmt.Errorf("error creating replacement node: %w", createErr)
+				return skipped, fmt.Errorf("error creating replacement node: %w", createErr)
 			}
 			targetFields = append(targetFields, createdField)
 		} else {
-			// may return multiple fields, always wrapped in a sequence node
-			foundFieldSequence, lookupErr := target.Pipe(&yaml.PathMatcher{Path: extendedPath.ResourcePath})
-			if lookupErr != nil {
-				return fmt.Errorf("error finding field in replacement target: %w", lookupErr)
-			}
-			targetFields, err = foundFieldSequence.Elements()
+			targetFields, err = lookupFields(target, extendedPath.ResourcePath)
 			if err != nil {
-				return fmt.Errorf("error fetching elements in replacement target: %w", err)
+				return skipped, err
 			}
 		}
 
+		if f.debug {
+			f.trace(target, "replacement %d target %d: field path %s matches %d field(s) in %s",
+				replacement, targetIndex, fp, len(targetFields), resid.FromRNode(target))
+		}
+		if strict && len(targetFields) == 0 {
+			return skipped, fmt.Errorf("field path %s matches nothing in %s", fp, resid.FromRNode(target))
+		}
+
 		for _, t := range targetFields {
+			if !extendedPath.HasExtensions() {
+				// the field is accessed as a whole
+				if err := f.documents.flushField(t.YNode()); err != nil {
+					return skipped, err
+				}
+				f.documents.forget(t.YNode())
+			}
+			if condition != nil && !create {
+				holds, conditionErr := conditionHolds(condition, t, extendedPath)
+				if conditionErr != nil {
+					return skipped, fmt.Errorf("while evaluating condition on field path %s in %s: %w",
+						fp, resid.FromRNode(target), conditionErr)
+				}
+				if !holds {
+					skipped++
+					continue
+				}
+			}
+			previous, present := fieldValue(t, extendedPath)
 			if err := setFieldValue(selector.Options, t, value, extendedPath); err != nil {
-				return err
+				if !strict && errors.Is(err, ErrNoMatch) {
+					continue
+				}
+				return skipped, fmt.Errorf("while setting field path %s in %s: %w", fp, resid.FromRNode(target), err)
 			}
+			if extendedPath.Sequence == nil {
+				f.recordWrite(target, t, value, extendedPath, replacement)
+			}
+			f.reportWrite(target, t, extendedPath, &fieldChange{
+				replacement: replacement,
+				target:      targetIndex,
+				previous:    previous,
+				created:     created || !present,
+			})
 		}
 	}
-	return nil
+	return skipped, nil
+}
+
+// conditionHolds returns true if the current value of field at extendedPath
+// satisfies condition. field is nil if it doesn't exist.
+func conditionHolds(condition *targetCondition, field *yaml.RNode, extendedPath *ExtendedPath) (bool, error) {
+	if yaml.IsMissingOrNull(field) {
+		return condition.holds("", false), nil
+	}
+	if !extendedPath.HasExtensions() {
+		if field.YNode().Kind == yaml.ScalarNode {
+			return condition.holds(field.YNode().Value, true), nil
+		}
+		return condition.holds(strings.TrimSuffix(field.MustString(), "\n"), true), nil
+	}
+	value, err := extendedPath.Get(field)
+	if errors.Is(err, ErrNoMatch) {
+		return condition.holds("", false), nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return condition.holds(string(value), true), nil
+}
+
+// renameTargetKeys renames the keys of the fields of target at extendedPath
+// with value, or moves them to the value path if extendedPath.KeyPath is true.
+// It returns the number of renamed fields and the number of fields
+// skipped because they don't satisfy condition.
+func renameTargetKeys(
+	target, value *yaml.RNode,
+	extendedPath *ExtendedPath,
+	condition *targetCondition,
+) (int, int, error) {
+	if value.YNode().Kind != yaml.ScalarNode {
+		return 0, 0, fmt.Errorf("renameKey option can only be used with scalar values")
+	}
+	path := extendedPath.ResourcePath
+	if len(path) == 0 {
+		return 0, 0, fmt.Errorf("cannot rename the key of the resource")
+	}
+	renamed, skipped := 0, 0
+	holds := func(field *yaml.RNode) (bool, error) {
+		if condition == nil {
+			return true, nil
+		}
+		ok, err := conditionHolds(condition, field, extendedPath)
+		if err != nil {
+			return false, fmt.Errorf("while evaluating condition: %w", err)
+		}
+		if !ok {
+			skipped++
+		}
+		return ok, nil
+	}
+
+	if extendedPath.HasExtensions() {
+		fields, err := lookupFields(target, path)
+		if err != nil {
+			return 0, 0, err
+		}
+		extendedPath.RenameKey = true
+		for _, field := range fields {
+			ok, err := holds(field)
+			if err != nil {
+				return renamed, skipped, err
+			}
+			if !ok {
+				continue
+			}
+			if err = extendedPath.Apply(field, value); err != nil {
+				return renamed, skipped, err
+			}
+			renamed++
+		}
+		return renamed, skipped, nil
+	}
+
+	parents := []*yaml.RNode{target}
+	if len(path) > 1 {
+		var err error
+		parents, err = lookupFields(target, path[:len(path)-1])
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	name := path[len(path)-1]
+	for _, parent := range parents {
+		if parent.YNode().Kind != yaml.MappingNode || parent.Field(name) == nil {
+			continue
+		}
+		ok, err := holds(parent.Field(name).Value)
+		if err != nil {
+			return renamed, skipped, err
+		}
+		if !ok {
+			continue
+		}
+		newPath := []string{value.YNode().Value}
+		root := parent
+		if extendedPath.KeyPath {
+			newPath = kyaml_utils.SmarterPathSplitter(value.YNode().Value, ".")
+			root = target
+		}
+		if err = moveField(parent, name, root, newPath); err != nil {
+			return renamed, skipped, fmt.Errorf("cannot rename key %s: %w", name, err)
+		}
+		renamed++
+	}
+	return renamed, skipped, nil
+}
+
+// lookupFields returns the fields of node matching path, that may contain
+// wildcards and keyed elements.
+func lookupFields(node *yaml.RNode, path []string) ([]*yaml.RNode, error) {
+	// may return multiple fields, always wrapped in a sequence node
+	matches, err := node.Pipe(&yaml.PathMatcher{Path: path})
+	if err != nil {
+		return nil, fmt.Errorf("error finding field in replacement target: %w", err)
+	}
+	fields, err := matches.Elements()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching elements in replacement target: %w", err)
+	}
+	return fields, nil
+}
+
+// recordWrite records that the replacement at index replacement wrote value
+// in field. If another replacement previously wrote a different value in the
+// same field, a warning is added to the filter results.
+func (f *extendedFilter) recordWrite(
+	target, field, value *yaml.RNode,
+	extendedPath *ExtendedPath,
+	replacement int,
+) {
+	key := fieldKey{node: field.YNode()}
+	if extendedPath.HasExtensions() {
+		key.extension = extendedPath.String()
+	}
+	written := value.YNode().Tag + ":" + value.YNode().Value
+	if value.YNode().Kind != yaml.ScalarNode {
+		written = value.MustString()
+	}
+	write := fieldWrite{value: written, replacement: replacement}
+	previous, ok := f.writes[key]
+	f.writes[key] = write
+	if !ok || previous.replacement == replacement || previous.value == write.value {
+		return
+	}
+	f.results = append(f.results, &framework.Result{
+		Message: fmt.Sprintf(
+			"field %s is written by replacement %d and overwritten by replacement %d",
+			extendedPath, previous.replacement, replacement),
+		Severity:    framework.Warning,
+		ResourceRef: resourceRef(target),
+		Field:       &framework.Field{Path: extendedPath.String()},
+		Tags:        map[string]string{"conflict": strconv.Itoa(replacement)},
+	})
+}
+
+// redactedValue replaces the values of the Secret fields in the report.
+const redactedValue = "<redacted>"
+
+// fieldChange describes a change made by a replacement to a target field.
+type fieldChange struct {
+	// replacement and target are the indexes of the replacement and of its
+	// target selector.
+	replacement int
+	target      int
+	// previous is the value of the field before the change.
+	previous string
+	// created is true if the field didn't exist before the change.
+	created bool
+}
+
+// fieldValue returns the current value of field at extendedPath and whether
+// it exists.
+func fieldValue(field *yaml.RNode, extendedPath *ExtendedPath) (string, bool) {
+	if yaml.IsMissingOrNull(field) {
+		return "", false
+	}
+	if extendedPath.HasExtensions() {
+		value, err := extendedPath.Get(field)
+		if err != nil {
+			return "", false
+		}
+		return string(value), true
+	}
+	if field.YNode().Kind == yaml.ScalarNode {
+		return field.YNode().Value, true
+	}
+	return strings.TrimSuffix(field.MustString(), "\n"), true
+}
+
+// reportWrite adds to the filter results an entry describing the change of
+// field in target.
+func (f *extendedFilter) reportWrite(
+	target, field *yaml.RNode,
+	extendedPath *ExtendedPath,
+	change *fieldChange,
+) {
+	current, _ := fieldValue(field, extendedPath)
+	action := "updated"
+	switch {
+	case change.created:
+		action = "created"
+	case current == change.previous:
+		action = "left unchanged"
+	}
+	var previous any
+	if !change.created {
+		previous = change.previous
+	}
+	if isSecret(target) {
+		current = redactedValue
+		if previous != nil {
+			previous = redactedValue
+		}
+	}
+	f.results = append(f.results, &framework.Result{
+		Message: fmt.Sprintf("replacement %d target %d %s field %s",
+			change.replacement, change.target, action, extendedPath),
+		Severity:    framework.Info,
+		ResourceRef: resourceRef(target),
+		Field: &framework.Field{
+			Path:          extendedPath.String(),
+			CurrentValue:  previous,
+			ProposedValue: current,
+		},
+		File: resourceFile(target),
+		Tags: map[string]string{
+			"replacement": strconv.Itoa(change.replacement),
+			"target":      strconv.Itoa(change.target),
+			"created":     strconv.FormatBool(change.created),
+		},
+	})
+}
+
+// reportRename adds to the filter results an entry describing the renaming of
+// count keys at extendedPath in target to the value of newKey.
+func (f *extendedFilter) reportRename(
+	target, newKey *yaml.RNode,
+	extendedPath *ExtendedPath,
+	replacement, targetIndex, count int,
+) {
+	path := extendedPath.ResourcePath
+	if extendedPath.HasExtensions() {
+		segments := *extendedPath.ExtendedSegments
+		path = segments[len(segments)-1].Path
+	}
+	var previous string
+	if len(path) > 0 {
+		previous = path[len(path)-1]
+	}
+	f.results = append(f.results, &framework.Result{
+		Message: fmt.Sprintf("replacement %d target %d renamed %d key(s) at %s",
+			replacement, targetIndex, count, extendedPath),
+		Severity:    framework.Info,
+		ResourceRef: resourceRef(target),
+		Field: &framework.Field{
+			Path:          extendedPath.String(),
+			CurrentValue:  previous,
+			ProposedValue: newKey.YNode().Value,
+		},
+		File: resourceFile(target),
+		Tags: map[string]string{
+			"replacement": strconv.Itoa(replacement),
+			"target":      strconv.Itoa(targetIndex),
+			"created":     "false",
+		},
+	})
+}
+
+// isSecret returns true if n is a Secret.
+func isSecret(n *yaml.RNode) bool {
+	return n.GetApiVersion() == "v1" && n.GetKind() == "Secret"
+}
+
+// resourceFile returns the file n comes from, or nil if it is unknown.
+func resourceFile(n *yaml.RNode) *framework.File {
+	path, index, err := kioutil.GetFileAnnotations(n)
+	if err != nil || path == "" {
+		return nil
+	}
+	file := &framework.File{Path: path}
+	if i, convErr := strconv.Atoi(index); convErr == nil {
+		file.Index = i
+	}
+	return file
+}
+
+// resourceRef returns the identifier of the resource n.
+func resourceRef(n *yaml.RNode) *yaml.ResourceIdentifier {
+	id := resid.FromRNode(n)
+	return &yaml.ResourceIdentifier{
+		TypeMeta: yaml.TypeMeta{APIVersion: id.ApiVersion(), Kind: id.Kind},
+		NameMeta: yaml.NameMeta{Name: id.Name, Namespace: id.Namespace},
+	}
 }
 
 func setFieldValue(
-	options *types.FieldOptions,
+	options *FieldOptions,
 	targetField *yaml.RNode,
 	value *yaml.RNode,
 	extendedPath *ExtendedPath,
@@ -254,6 +1042,9 @@ func setFieldValue(
 		if extendedPath.HasExtensions() {
 			return fmt.Errorf("delimiter option cannot be used with extensions")
 		}
+		if extendedPath.Sequence != nil {
+			return fmt.Errorf("delimiter option cannot be used with sequence insertion")
+		}
 		if targetField.YNode().Kind != yaml.ScalarNode {
 			return fmt.Errorf("delimiter option can only be used with scalar nodes")
 		}
@@ -271,20 +1062,70 @@ func setFieldValue(
 		value.YNode().Value = strings.Join(tv, options.Delimiter)
 	}
 
+	if options != nil && options.Type != "" {
+		typed, err := GetTypedValue(value.YNode(), options.Type)
+		if err != nil {
+			return err
+		}
+		value.SetYNode(typed)
+	}
+
+	if extendedPath.Sequence != nil && !extendedPath.HasExtensions() {
+		return insertInSequence(targetField, value.YNode(), extendedPath.Sequence)
+	}
+
 	if targetField.YNode().Kind == yaml.ScalarNode {
 		return extendedPath.Apply(targetField, value)
-	} else {
-		if extendedPath.HasExtensions() {
-			return fmt.Errorf("path extensions should start at a scalar node")
-		}
+	}
+	if extendedPath.HasExtensions() {
+		return fmt.Errorf("path extensions should start at a scalar node")
+	}
 
-		targetField.SetYNode(value.YNode())
+	targetField.SetYNode(value.YNode())
+	return setKeyField(targetField, extendedPath.ResourcePath)
+}
+
+// isBase64Source returns true if the source value of the replacement at index
+// replacement is base64 encoded by its encoding option.
+func (f *extendedFilter) isBase64Source(replacement int) bool {
+	source := f.Replacements[replacement].Source
+	return source != nil && source.Options != nil &&
+		getEncodingType(source.Options.Encoding) == Base64Encoding
+}
+
+// isBase64Field returns true if the field at path in node contains base64
+// encoded data, i.e. if it is an entry of the data of a Secret or of the
+// binaryData of a ConfigMap.
+func isBase64Field(node *yaml.RNode, path []string) bool {
+	if len(path) != 2 || node.GetApiVersion() != "v1" {
+		return false
+	}
+	switch node.GetKind() {
+	case "Secret":
+		return path[0] == "data"
+	case "ConfigMap":
+		return path[0] == "binaryData"
 	}
+	return false
+}
 
-	return nil
+// decodeBase64Value returns a copy of the scalar node rn with its value
+// decoded.
+func decodeBase64Value(rn *yaml.RNode) (*yaml.RNode, error) {
+	if rn.YNode().Kind != yaml.ScalarNode {
+		return rn, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(yaml.GetValue(rn))
+	if err != nil {
+		return nil, fmt.Errorf("while decoding base64: %w", err)
+	}
+	n := rn.Copy()
+	n.YNode().Value = string(decoded)
+	n.YNode().Tag = yaml.NodeTagString
+	return n, nil
 }
 
-func shouldCreateField(options *types.FieldOptions, fieldPath []string) (bool, error) {
+func shouldCreateField(options *FieldOptions, fieldPath []string) (bool, error) {
 	if options == nil || !options.Create {
 		return false, nil
 	}
@@ -369,31 +1210,78 @@ func prevIds(n *yaml.RNode) ([]resid.ResId, error) {
 // It also provides helpers for changing content in base64 encoded properties
 // as well as a simple regexp based replacer for edge cases.
 //
+// When Strict is set, the transformer fails if a target selects no resource,
+// if a field path matches nothing or if a regex extended path doesn't match
+// any text. Each target can override this setting with its own strict field.
+//
+// Values read from the data of a Secret or the binaryData of a ConfigMap are
+// base64 decoded and values written to them are base64 encoded, even when an
+// extended path is used underneath. RawSecretData disables this behavior and
+// the raw target option disables it for a single target.
+//
+// Source gives the path of a resource file or kustomization containing the
+// source resources instead of the transformed resources. It can also be a
+// virtual source exposing environment variables (env://), the git repository
+// properties (git://) or the content of a file (file://) as a synthetic
+// resource. Each replacement can take its source resources from a different
+// path with its from field.
+//
+// Source can also be a list of paths and glob patterns. The resources with
+// the same id in several paths are deep merged, the later paths taking
+// precedence. In this case, an info result reports the paths the value of
+// each replacement comes from.
+//
+// Direction reverses the replacements when set to extract: the value of the
+// target fields, read through their extended paths, is written back into the
+// source field. When the source comes from resource files, the modified files
+// are saved in place once all of them are serialized. When the targets of a
+// replacement disagree, a warning is added to the results and the source is
+// left unchanged.
+//
 // Configuration of replacements can be found in the [kustomize doc].
 //
 // [kustomize doc]: https://kubectl.docs.kubernetes.io/references/kustomize/kustomization/replacements/
 type ExtendedReplacementTransformerPlugin struct {
 	h               *resmap.PluginHelpers
-	Source          string                   `json:"source,omitempty"       yaml:"source,omitempty"`
-	ReplacementList []types.ReplacementField `json:"replacements,omitempty" yaml:"replacements,omitempty"`
-	Replacements    []types.Replacement      `json:"omitempty"              yaml:"omitempty"`
+	Source          SourcePaths        `json:"source,omitempty"       yaml:"source,omitempty"`
+	Strict          bool               `json:"strict,omitempty"       yaml:"strict,omitempty"`
+	RawSecretData   bool               `json:"rawSecretData,omitempty" yaml:"rawSecretData,omitempty"`
+	Direction       string             `json:"direction,omitempty"    yaml:"direction,omitempty"`
+	ReplacementList []ReplacementField `json:"replacements,omitempty" yaml:"replacements,omitempty"`
+	Replacements    []Replacement      `json:"omitempty"              yaml:"omitempty"`
+	// FileSystem holds the source files of the extract direction. It
+	// defaults to the local disk.
+	FileSystem filesys.FileSystem `json:"-" yaml:"-"`
+	results    framework.Results
+	debug      bool
 }
 
 // Config configures the plugin.
 func (p *ExtendedReplacementTransformerPlugin) Config(
 	h *resmap.PluginHelpers, c []byte,
 ) error {
-	p.ReplacementList = []types.ReplacementField{}
+	p.ReplacementList = []ReplacementField{}
 	if err := yaml.Unmarshal(c, p); err != nil {
 		return fmt.Errorf("while configuring ExtendedReplacementTransformerPlugin: %w", err)
 	}
 	p.h = h
+	if p.Direction != "" && p.Direction != DirectionApply && p.Direction != DirectionExtract {
+		return fmt.Errorf("unknown replacement direction %q, expected %s or %s",
+			p.Direction, DirectionApply, DirectionExtract)
+	}
+	var metadata struct {
+		Metadata yaml.ObjectMeta `yaml:"metadata,omitempty"`
+	}
+	if err := yaml.Unmarshal(c, &metadata); err != nil {
+		return fmt.Errorf("while reading ExtendedReplacementTransformerPlugin metadata: %w", err)
+	}
+	p.debug = utils.IsDebug(metadata.Metadata.Annotations)
 
 	for _, r := range p.ReplacementList {
 		if r.Path != "" && (r.Source != nil || len(r.Targets) != 0) {
 			return fmt.Errorf("cannot specify both path and inline replacement")
 		}
-		repl := []types.Replacement{r.Replacement}
+		repl := []Replacement{r.Replacement}
 		if r.Path != "" {
 			// load the replacement from the path
 			content, err := h.Loader().Load(r.Path)
@@ -410,17 +1298,17 @@ func (p *ExtendedReplacementTransformerPlugin) Config(
 			//nolint:exhaustive // we only support unmarshaling to map or slice, so we don't need to check all kinds
 			switch items.Kind() {
 			case reflect.Slice:
-				value := []types.Replacement{}
+				value := []Replacement{}
 				if err := yaml.Unmarshal(content, &value); err != nil {
 					return fmt.Errorf("while unmarshaling replacement path %s: %w", r.Path, err)
 				}
 				repl = value
 			case reflect.Map:
-				value := types.Replacement{}
+				value := Replacement{}
 				if err := yaml.Unmarshal(content, &value); err != nil {
 					return fmt.Errorf("while unmarshaling replacement path %s: %w", r.Path, err)
 				}
-				repl = []types.Replacement{value}
+				repl = []Replacement{value}
 			default:
 				return fmt.Errorf("unsupported replacement type encountered within replacement path: %v", items.Kind())
 			}
@@ -429,13 +1317,31 @@ func (p *ExtendedReplacementTransformerPlugin) Config(
 		p.Replacements = append(p.Replacements, repl...)
 	}
 
+	for _, r := range p.Replacements {
+		for _, t := range r.Targets {
+			if err := compileSelectors(append([]*Selector{t.Select}, t.Reject...)); err != nil {
+				return fmt.Errorf("while compiling replacement target selectors: %w", err)
+			}
+		}
+	}
+
 	return nil
 }
 
+// loadSource loads the resources of the replacement source at path. path can
+// be a resource file, a kustomization or a virtual source (see
+// [loadVirtualSource]).
 func loadSource(h *resmap.PluginHelpers, path string) (resmap.ResMap, error) {
 	if path == "" {
 		return resmap.New(), nil
 	}
+	virtual, err := loadVirtualSource(h, path)
+	if err != nil {
+		return nil, fmt.Errorf("while loading virtual source %s: %w", path, err)
+	}
+	if virtual != nil {
+		return utils.ResourceMapFromNodes([]*yaml.RNode{virtual}), nil
+	}
 	source, err := h.ResmapFactory().FromFile(h.Loader(), path)
 	if err != nil {
 		// FIXME: Before we had an exported type. Now the type is internal to kustomize, so we have to check the error
@@ -456,21 +1362,53 @@ func loadSource(h *resmap.PluginHelpers, path string) (resmap.ResMap, error) {
 
 // Transform performs the configured replacements in the specified resource map.
 func (p *ExtendedReplacementTransformerPlugin) Transform(m resmap.ResMap) error {
-	source, err := loadSource(p.h, p.Source)
+	if p.Direction == DirectionExtract {
+		return p.extract(m)
+	}
+	sources, err := loadSourceLayers(p.h, p.Source)
 	if err != nil {
-		return fmt.Errorf("while loading source from path %s: %w", p.Source, err)
+		return fmt.Errorf("while loading source from path %s: %w", strings.Join(p.Source, ", "), err)
 	}
 
-	err = m.ApplyFilter(extendedFilter{
-		Replacements: p.Replacements,
-		sourceNodes:  source.ToRNodeSlice(),
-	})
+	replacementSources := make([]*sourceLayers, len(p.Replacements))
+	loaded := map[string]*sourceLayers{}
+	for i, r := range p.Replacements {
+		if len(r.From) == 0 {
+			continue
+		}
+		key := strings.Join(r.From, "\n")
+		if _, ok := loaded[key]; !ok {
+			loaded[key], err = loadSourceLayers(p.h, r.From)
+			if err != nil {
+				return fmt.Errorf("while loading source of replacement %d from path %s: %w",
+					i, strings.Join(r.From, ", "), err)
+			}
+		}
+		replacementSources[i] = loaded[key]
+	}
+
+	filter := &extendedFilter{
+		Replacements:       p.Replacements,
+		Strict:             p.Strict,
+		RawSecretData:      p.RawSecretData,
+		sources:            sources,
+		replacementSources: replacementSources,
+		debug:              p.debug,
+	}
+	err = m.ApplyFilter(filter)
+	p.results = filter.results
 	if err != nil {
 		return fmt.Errorf("while applying replacements: %w", err)
 	}
 	return nil
 }
 
+// Results returns the results of the last transformation, like the warnings
+// about fields written by several replacements.
+func (p *ExtendedReplacementTransformerPlugin) Results() framework.Results {
+	return p.results
+}
+
 // NewExtendedReplacementTransformerPlugin returns a newly created [ExtendedReplacementTransformerPlugin].
 func NewExtendedReplacementTransformerPlugin() resmap.TransformerPlugin {
 	return &ExtendedReplacementTransformerPlugin{}
@@ -0,0 +1,875 @@
+package extras_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+
+	"github.com/karmafun/karmafun/pkg/extras"
+	"github.com/karmafun/karmafun/pkg/plugins"
+	"github.com/karmafun/karmafun/pkg/utils"
+)
+
+const replacementResources = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: configuration-map
+data:
+  repoURL: https://github.com/karmafun/karmafun.git
+  targetRevision: deploy/citest
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: app
+  namespace: argocd
+spec:
+  source:
+    repoURL: https://github.com/upstream/app.git
+    targetRevision: main
+    helm:
+      parameters:
+      - name: common.repoURL
+        value: https://github.com/upstream/app.git
+      values: |
+        common:
+          targetRevision: main
+        sish: |
+          HostName holepunch.in
+`
+
+// runReplacementTransformer applies a replacement transformer configured with
+// config to input and returns the resulting resources along with the results.
+func runReplacementTransformer(t *testing.T, config, input string) (string, framework.Results, error) {
+	t.Helper()
+	p := &extras.ExtendedReplacementTransformerPlugin{}
+	nodes, err := transformResources(t, p, config, input)
+	if err != nil {
+		return "", p.Results(), err
+	}
+	return resourcesString(t, nodes), p.Results(), nil
+}
+
+// withoutReport returns results without the per field replacement report
+// entries.
+func withoutReport(results framework.Results) framework.Results {
+	var out framework.Results
+	for _, r := range results {
+		if _, ok := r.Tags["replacement"]; !ok {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func TestReplacementStrict(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name: "lenient target selecting nothing",
+			config: `
+replacements:
+  - source:
+      kind: ConfigMap
+      fieldPath: data.repoURL
+    targets:
+      - select:
+          kind: Application
+          name: ap
+        fieldPaths:
+          - spec.source.repoURL
+`,
+		},
+		{
+			name: "strict target selecting nothing",
+			config: `
+strict: true
+replacements:
+  - source:
+      kind: ConfigMap
+      fieldPath: data.repoURL
+    targets:
+      - select:
+          kind: Application
+          name: ap
+        fieldPaths:
+          - spec.source.repoURL
+`,
+			wantErr: "matches no resource",
+		},
+		{
+			name: "strict keyed field path matching nothing",
+			config: `
+strict: true
+replacements:
+  - source:
+      kind: ConfigMap
+      fieldPath: data.repoURL
+    targets:
+      - select:
+          kind: Application
+        fieldPaths:
+          - spec.source.helm.parameters.[name=common.repoUrl].value
+`,
+			wantErr: "matches nothing",
+		},
+		{
+			name: "target level strict overrides transformer",
+			config: `
+strict: true
+replacements:
+  - source:
+      kind: ConfigMap
+      fieldPath: data.repoURL
+    targets:
+      - select:
+          kind: Application
+        strict: false
+        fieldPaths:
+          - spec.source.helm.parameters.[name=common.repoUrl].value
+`,
+		},
+		{
+			name: "strict regex matching no text",
+			config: `
+replacements:
+  - source:
+      kind: ConfigMap
+      fieldPath: data.repoURL
+    targets:
+      - select:
+          kind: Application
+        strict: true
+        fieldPaths:
+          - spec.source.helm.values.!!yaml.sish.!!regex.^Host\s+(\S+)$.1
+`,
+			wantErr: extras.ErrNoMatch.Error(),
+		},
+		{
+			name: "lenient regex matching no text",
+			config: `
+replacements:
+  - source:
+      kind: ConfigMap
+      fieldPath: data.repoURL
+    targets:
+      - select:
+          kind: Application
+        fieldPaths:
+          - spec.source.helm.values.!!yaml.sish.!!regex.^Host\s+(\S+)$.1
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := require.New(t)
+			got, _, err := runReplacementTransformer(t, tt.config, replacementResources)
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			req.Equal(replacementResources, got, "resources should be unchanged")
+		})
+	}
+}
+
+func TestReplacementConflictWarning(t *testing.T) {
+	t.Parallel()
+	req := require.New(t)
+	config := `
+replacements:
+  - source:
+      kind: ConfigMap
+      fieldPath: data.repoURL
+    targets:
+      - select:
+          kind: Application
+        fieldPaths:
+          - spec.source.helm.values.!!yaml.common.targetRevision
+  - source:
+      kind: ConfigMap
+      fieldPath: data.targetRevision
+    targets:
+      - select:
+          kind: Application
+        fieldPaths:
+          - spec.source.helm.values.!!yaml.common.targetRevision
+          - spec.source.targetRevision
+`
+	_, results, err := runReplacementTransformer(t, config, replacementResources)
+	results = withoutReport(results)
+	req.NoError(err)
+	req.Len(results, 1, "there should be one conflict")
+	req.Equal(framework.Warning, results[0].Severity)
+	req.Equal("app", results[0].ResourceRef.Name)
+	req.Equal("spec.source.helm.values.!!yaml.common.targetRevision", results[0].Field.Path)
+	req.Equal("1", results[0].Tags["conflict"])
+}
+
+const secretResources = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: configuration-map
+data:
+  password: s3cr3t
+  url: https://new.example.com
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: credentials
+type: Opaque
+data:
+  password: b2xk
+  config.yaml: dXJsOiBodHRwczovL29sZC5leGFtcGxlLmNvbQpwb3J0OiA4MAo=
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: binary-map
+binaryData:
+  password: b2xk
+`
+
+func TestReplacementBase64Fields(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		config  string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "secret data is encoded",
+			config: `
+replacements:
+  - source:
+      kind: ConfigMap
+      name: configuration-map
+      fieldPath: data.password
+    targets:
+      - select:
+          kind: Secret
+        fieldPaths:
+          - data.password
+`,
+			want: "  password: czNjcjN0\n",
+		},
+		{
+			name: "secret data is decoded under extended path",
+			config: `
+replacements:
+  - source:
+      kind: ConfigMap
+      name: configuration-map
+      fieldPath: data.url
+    targets:
+      - select:
+          kind: Secret
+        fieldPaths:
+          - data.config\.yaml.!!yaml.url
+`,
+			want: "  config.yaml: dXJsOiBodHRwczovL25ldy5leGFtcGxlLmNvbQpwb3J0OiA4MAo=\n",
+		},
+		{
+			name: "explicit base64 segment is kept",
+			config: `
+replacements:
+  - source:
+      kind: ConfigMap
+      name: configuration-map
+      fieldPath: data.url
+    targets:
+      - select:
+          kind: Secret
+        fieldPaths:
+          - data.config\.yaml.!!base64.!!yaml.url
+`,
+			want: "  config.yaml: dXJsOiBodHRwczovL25ldy5leGFtcGxlLmNvbQpwb3J0OiA4MAo=\n",
+		},
+		{
+			name: "configmap binary data is encoded",
+			config: `
+replacements:
+  - source:
+      kind: ConfigMap
+      name: configuration-map
+      fieldPath: data.password
+    targets:
+      - select:
+          kind: ConfigMap
+          name: binary-map
+        fieldPaths:
+          - binaryData.password
+`,
+			want: "  password: czNjcjN0\n",
+		},
+		{
+			name: "raw target option",
+			config: `
+replacements:
+  - source:
+      kind: ConfigMap
+      name: configuration-map
+      fieldPath: data.password
+    targets:
+      - select:
+          kind: Secret
+        fieldPaths:
+          - data.password
+        options:
+          raw: true
+`,
+			want: "  password: s3cr3t\n",
+		},
+		{
+			name: "secret source is decoded",
+			config: `
+replacements:
+  - source:
+      kind: Secret
+      fieldPath: data.password
+    targets:
+      - select:
+          kind: ConfigMap
+          name: configuration-map
+        fieldPaths:
+          - data.password
+`,
+			want: "  password: old\n",
+		},
+		{
+			name: "secret to binary data copy",
+			config: `
+replacements:
+  - source:
+      kind: Secret
+      fieldPath: data.password
+    targets:
+      - select:
+          kind: ConfigMap
+          name: binary-map
+        fieldPaths:
+          - binaryData.password
+`,
+			want: "binaryData:\n  password: b2xk\n",
+		},
+		{
+			name: "raw secret data",
+			config: `
+rawSecretData: true
+replacements:
+  - source:
+      kind: Secret
+      fieldPath: data.password
+    targets:
+      - select:
+          kind: ConfigMap
+          name: configuration-map
+        fieldPaths:
+          - data.password
+`,
+			want: "  password: b2xk\n",
+		},
+		{
+			name: "encoded source",
+			config: `
+replacements:
+  - source:
+      kind: ConfigMap
+      name: configuration-map
+      fieldPath: data.password
+      options:
+        encoding: base64
+    targets:
+      - select:
+          kind: Secret
+        fieldPaths:
+          - data.password
+`,
+			wantErr: "remove the source encoding or set the raw target option",
+		},
+		{
+			name: "encoded source with raw target",
+			config: `
+replacements:
+  - source:
+      kind: ConfigMap
+      name: configuration-map
+      fieldPath: data.password
+      options:
+        encoding: base64
+    targets:
+      - select:
+          kind: Secret
+        fieldPaths:
+          - data.password
+        options:
+          raw: true
+`,
+			want: "  password: czNjcjN0\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := require.New(t)
+			got, _, err := runReplacementTransformer(t, tt.config, secretResources)
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			req.Contains(got, tt.want)
+		})
+	}
+}
+
+func TestReplacementCondition(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		path    string
+		when    string
+		options string
+		want    string
+		skipped string
+		wantErr string
+	}{
+		{
+			name: "equals",
+			path: "spec.source.targetRevision",
+			when: "{equals: main}",
+			want: "    targetRevision: deploy/citest\n",
+		},
+		{
+			name:    "not equals",
+			path:    "spec.source.targetRevision",
+			when:    "{equals: master}",
+			want:    "    targetRevision: main\n",
+			skipped: "skipped 1 field(s)",
+		},
+		{
+			name: "matches",
+			path: "spec.source.repoURL",
+			when: "{matches: '^https://github.com/upstream/'}",
+			want: "    repoURL: deploy/citest\n",
+		},
+		{
+			name:    "matches with wildcard",
+			path:    "spec.source.helm.parameters.*.value",
+			when:    "{matches: '^https://github.com/karmafun/'}",
+			want:    "        value: https://github.com/upstream/app.git\n",
+			skipped: "skipped 1 field(s)",
+		},
+		{
+			name: "equals in extended path",
+			path: "spec.source.helm.values.!!yaml.common.targetRevision",
+			when: "{equals: main}",
+			want: "        common:\n          targetRevision: deploy/citest\n",
+		},
+		{
+			name:    "not equals in extended path",
+			path:    "spec.source.helm.values.!!yaml.common.targetRevision",
+			when:    "{equals: deploy/prod}",
+			want:    "        common:\n          targetRevision: main\n",
+			skipped: "skipped 1 field(s)",
+		},
+		{
+			name: "equals in regex capture group",
+			path: "spec.source.helm.values.!!yaml.sish.!!regex.^HostName\\s+(\\S+)$.1",
+			when: "{equals: holepunch.in}",
+			want: "          HostName deploy/citest\n",
+		},
+		{
+			name:    "absent",
+			path:    "spec.source.path",
+			when:    "{absent: true}",
+			options: "{create: true}",
+			want:    "    path: deploy/citest\n",
+		},
+		{
+			name:    "absent with existing field",
+			path:    "spec.source.targetRevision",
+			when:    "{absent: true}",
+			options: "{create: true}",
+			want:    "    targetRevision: main\n",
+			skipped: "skipped 1 field(s)",
+		},
+		{
+			name:    "absent in extended path",
+			path:    "spec.source.helm.values.!!yaml.common.branch",
+			when:    "{absent: true}",
+			options: "{create: true}",
+			want:    "          branch: deploy/citest\n",
+		},
+		{
+			name:    "invalid condition",
+			path:    "spec.source.targetRevision",
+			when:    "{absent: true, equals: main}",
+			wantErr: "cannot be combined",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := require.New(t)
+			options := tt.options
+			if options == "" {
+				options = "{}"
+			}
+			config := fmt.Sprintf(`
+replacements:
+  - source:
+      kind: ConfigMap
+      fieldPath: data.targetRevision
+    targets:
+      - select:
+          kind: Application
+        fieldPaths:
+          - %s
+        when: %s
+        options: %s
+`, tt.path, tt.when, options)
+			got, results, err := runReplacementTransformer(t, config, replacementResources)
+			results = withoutReport(results)
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			req.Contains(got, tt.want)
+			if tt.skipped == "" {
+				req.Empty(results)
+				return
+			}
+			req.Len(results, 1)
+			req.Equal(framework.Info, results[0].Severity)
+			req.Contains(results[0].Message, tt.skipped)
+		})
+	}
+}
+
+func TestReplacementReport(t *testing.T) {
+	t.Parallel()
+	req := require.New(t)
+	input := replacementResources + `---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: repository
+  annotations:
+    config.kubernetes.io/path: secrets/repository.yaml
+    config.kubernetes.io/index: '1'
+stringData:
+  url: https://github.com/upstream/app.git
+`
+	config := `
+replacements:
+  - source: {kind: ConfigMap, fieldPath: data.targetRevision}
+    targets:
+      - select: {kind: Application}
+        fieldPaths:
+          - spec.source.targetRevision
+          - spec.source.helm.values.!!yaml.common.targetRevision
+      - select: {kind: Application}
+        fieldPaths: [spec.source.path]
+        options: {create: true}
+  - source: {kind: ConfigMap, fieldPath: data.repoURL}
+    targets:
+      - select: {kind: Secret}
+        fieldPaths: [stringData.url]
+`
+	_, results, err := runReplacementTransformer(t, config, input)
+	req.NoError(err)
+	req.Len(results, 4)
+
+	type entry struct {
+		message  string
+		path     string
+		previous any
+		proposed any
+		created  string
+	}
+	got := make([]entry, 0, len(results))
+	for _, r := range results {
+		req.Equal(framework.Info, r.Severity)
+		got = append(got, entry{
+			message:  r.Message,
+			path:     r.Field.Path,
+			previous: r.Field.CurrentValue,
+			proposed: r.Field.ProposedValue,
+			created:  r.Tags["created"],
+		})
+	}
+	req.Equal([]entry{
+		{
+			message:  "replacement 0 target 0 updated field spec.source.targetRevision",
+			path:     "spec.source.targetRevision",
+			previous: "main",
+			proposed: "deploy/citest",
+			created:  "false",
+		},
+		{
+			message:  "replacement 0 target 0 updated field spec.source.helm.values.!!yaml.common.targetRevision",
+			path:     "spec.source.helm.values.!!yaml.common.targetRevision",
+			previous: "main",
+			proposed: "deploy/citest",
+			created:  "false",
+		},
+		{
+			message:  "replacement 0 target 1 created field spec.source.path",
+			path:     "spec.source.path",
+			proposed: "deploy/citest",
+			created:  "true",
+		},
+		{
+			message:  "replacement 1 target 0 updated field stringData.url",
+			path:     "stringData.url",
+			previous: "<redacted>",
+			proposed: "<redacted>",
+			created:  "false",
+		},
+	}, got)
+
+	req.Equal("app", results[0].ResourceRef.Name)
+	req.Nil(results[0].File)
+	req.Equal("repository", results[3].ResourceRef.Name)
+	req.Equal(&framework.File{Path: "secrets/repository.yaml", Index: 1}, results[3].File)
+}
+
+func TestReplacementDebug(t *testing.T) {
+	t.Parallel()
+	req := require.New(t)
+	input := fmt.Sprintf(`apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: prod-app
+  annotations:
+    %s: app
+    %s: argocd
+    %s: Application
+  labels:
+    team: alpha
+spec:
+  source:
+    targetRevision: main
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: other
+  labels:
+    team: beta
+spec:
+  source:
+    targetRevision: main
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: app-legacy
+  labels:
+    team: alpha
+spec:
+  source:
+    targetRevision: main
+`, utils.BuildAnnotationPreviousNames, utils.BuildAnnotationPreviousNamespaces, utils.BuildAnnotationPreviousKinds)
+	config := `
+metadata:
+  annotations:
+    config.karmafun.dev/debug: "true"
+replacements:
+  - sourceValue: selected
+    targets:
+      - select: {kind: Application, name: app, labelSelector: team=alpha}
+        reject: [{name: app-legacy}]
+        fieldPaths: [spec.source.targetRevision]
+      - select: {kind: Application, labelSelector: team=alpha}
+        reject: [{name: app-legacy}]
+        fieldPaths: [spec.source.targetRevision]
+`
+	_, results, err := runReplacementTransformer(t, config, input)
+	req.NoError(err)
+	var messages []string
+	for _, r := range results {
+		if r.Tags["debug"] == "true" {
+			messages = append(messages, r.Message)
+		}
+	}
+	want := []string{
+		"target 0: Application.v1alpha1.argoproj.io/prod-app.[noNs] has previous ids " +
+			"Application.v1alpha1.argoproj.io/app.argocd",
+		"target 0: Application.v1alpha1.argoproj.io/prod-app.[noNs] id " +
+			"Application.v1alpha1.argoproj.io/prod-app.[noNs] doesn't match select",
+		"target 0: Application.v1alpha1.argoproj.io/prod-app.[noNs] is selected by its previous id " +
+			"Application.v1alpha1.argoproj.io/app.argocd",
+		"target 0: field path spec.source.targetRevision matches 1 field(s)",
+		"target 0: Application.v1alpha1.argoproj.io/other.[noNs] is not selected: " +
+			"labelSelector \"team=alpha\" doesn't match",
+		"target 0: Application.v1alpha1.argoproj.io/app-legacy.[noNs] id " +
+			"Application.v1alpha1.argoproj.io/app-legacy.[noNs] doesn't match select",
+		"target 0: Application.v1alpha1.argoproj.io/app-legacy.[noNs] is not selected: no id matches",
+		"target 1: Application.v1alpha1.argoproj.io/prod-app.[noNs] has previous ids",
+		"target 1: Application.v1alpha1.argoproj.io/prod-app.[noNs] is selected by its id",
+		"target 1: field path spec.source.targetRevision matches 1 field(s)",
+		"target 1: Application.v1alpha1.argoproj.io/other.[noNs] is not selected",
+		"target 1: Application.v1alpha1.argoproj.io/app-legacy.[noNs] id " +
+			"Application.v1alpha1.argoproj.io/app-legacy.[noNs] is rejected by reject selector 0",
+		"target 1: Application.v1alpha1.argoproj.io/app-legacy.[noNs] is not selected: no id matches",
+	}
+	req.Len(messages, len(want))
+	for i, w := range want {
+		req.True(strings.HasPrefix(messages[i], "debug: replacement 0 "), messages[i])
+		req.Contains(messages[i], w)
+	}
+}
+
+func TestReplacementAtomic(t *testing.T) {
+	t.Parallel()
+	req := require.New(t)
+
+	config := `
+replacements:
+  - source: {kind: ConfigMap, fieldPath: data.targetRevision}
+    targets:
+      - select: {kind: Application}
+        fieldPaths: [spec.source.targetRevision, spec.source.helm.values.!!yaml.common.targetRevision]
+  - source: {kind: ConfigMap, fieldPath: data.repoURL}
+    targets:
+      - select: {kind: Deployment}
+        fieldPaths: [spec.template.metadata.annotations.repoURL]
+        strict: true
+  - source: {kind: ConfigMap, fieldPath: data.repoURL}
+    sourceValue: https://example.com/app.git
+    targets:
+      - select: {kind: Application}
+  - sourceValue: https://example.com/app.git
+    targets:
+      - select: {kind: Application}
+        fieldPaths: [spec.source.repoURL]
+  - sourceValue: https://example.com/other.git
+    targets:
+      - select: {kind: Application}
+        fieldPaths: [spec.source.repoURL]
+`
+	helpers, err := plugins.NewPluginHelpers()
+	req.NoError(err, "creating plugin helpers should not error")
+	p, ok := extras.NewExtendedReplacementTransformerPlugin().(*extras.ExtendedReplacementTransformerPlugin)
+	req.True(ok, "plugin should be an ExtendedReplacementTransformerPlugin")
+	req.NoError(p.Config(helpers, []byte(config)), "configuring plugin should not error")
+
+	nodes, err := kio.FromBytes([]byte(replacementResources))
+	req.NoError(err, "reading input resources should not error")
+	rm := utils.ResourceMapFromNodes(nodes)
+
+	err = p.Transform(rm)
+	req.ErrorContains(err, "replacement 1: target selector")
+	req.ErrorContains(err, "replacement 2: source and sourceValue are mutually exclusive")
+	req.NotContains(err.Error(), "replacement 0")
+	req.NotContains(err.Error(), "replacement 3")
+	req.NotContains(err.Error(), "replacement 4")
+	req.Empty(p.Results(), "no field write nor conflict should be reported")
+
+	var b bytes.Buffer
+	req.NoError(kio.ByteWriter{Writer: &b}.Write(rm.ToRNodeSlice()))
+	req.Equal(replacementResources, b.String(), "resources should be left untouched")
+}
+
+// benchmarkResources returns count applications with embedded Helm values.
+func benchmarkResources(count int) string {
+	var b strings.Builder
+	b.WriteString(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: configuration-map
+data:
+  repoURL: https://github.com/karmafun/karmafun.git
+  targetRevision: deploy/citest
+`)
+	for i := range count {
+		fmt.Fprintf(&b, `---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: app-%d
+  namespace: argocd
+  labels:
+    team: team-%d
+spec:
+  source:
+    repoURL: https://github.com/upstream/app.git
+    targetRevision: main
+    helm:
+      values: |
+        common:
+          repoURL: https://github.com/upstream/app.git
+          targetRevision: main
+          replicas: 1
+        ingress:
+          hosts:
+          - app-%d.example.com
+`, i, i%10, i)
+	}
+	return b.String()
+}
+
+// benchmarkConfig returns a configuration with count replacements writing in
+// the embedded values of each application and one replacement per team.
+func benchmarkConfig(count int) string {
+	var b strings.Builder
+	b.WriteString("replacements:\n")
+	for range count {
+		b.WriteString(`  - source: {kind: ConfigMap, fieldPath: data.targetRevision}
+    targets:
+      - select: {kind: Application}
+        fieldPaths:
+          - spec.source.targetRevision
+          - spec.source.helm.values.!!yaml.common.targetRevision
+          - spec.source.helm.values.!!yaml.common.repoURL
+`)
+	}
+	for team := range 10 {
+		fmt.Fprintf(&b, `  - source: {kind: ConfigMap, fieldPath: data.repoURL}
+    targets:
+      - select: {kind: Application, labelSelector: team=team-%d}
+        fieldPaths: [spec.source.repoURL]
+`, team)
+	}
+	return b.String()
+}
+
+func BenchmarkReplacement(b *testing.B) {
+	for _, count := range []int{100, 1000} {
+		b.Run(fmt.Sprintf("%d applications", count), func(b *testing.B) {
+			req := require.New(b)
+			helpers, err := plugins.NewPluginHelpers()
+			req.NoError(err)
+			p, ok := extras.NewExtendedReplacementTransformerPlugin().(*extras.ExtendedReplacementTransformerPlugin)
+			req.True(ok)
+			req.NoError(p.Config(helpers, []byte(benchmarkConfig(10))))
+			input := []byte(benchmarkResources(count))
+
+			b.ResetTimer()
+			for range b.N {
+				b.StopTimer()
+				nodes, readErr := kio.FromBytes(input)
+				req.NoError(readErr)
+				rm := utils.ResourceMapFromNodes(nodes)
+				b.StartTimer()
+				req.NoError(p.Transform(rm))
+			}
+		})
+	}
+}
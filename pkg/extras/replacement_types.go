@@ -0,0 +1,301 @@
+package extras
+
+import (
+	"fmt"
+	"regexp"
+
+	"sigs.k8s.io/kustomize/api/types"
+	kyaml_utils "sigs.k8s.io/kustomize/kyaml/utils"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// Replacement defines how to perform a substitution, where it is from and
+// where it is to.
+//
+// It mirrors [types.Replacement] but uses [TargetSelector] for its targets in
+// order to support the karmafun specific target options.
+type Replacement struct {
+	// The source of the value.
+	Source *SourceSelector `json:"source,omitempty" yaml:"source,omitempty"`
+
+	// The N fields to write the value to.
+	Targets []*TargetSelector `json:"targets,omitempty" yaml:"targets,omitempty"`
+
+	// Used to define an static value
+	SourceValue *string `json:"sourceValue,omitempty" yaml:"sourceValue,omitempty"`
+
+	// Paths of the resources in which the source is selected, overriding the
+	// transformer source. Each path can be a resource file, a kustomization,
+	// a glob pattern or a virtual source like env://CI_COMMIT_SHA, git:// or
+	// file://ca.crt.
+	From SourcePaths `json:"from,omitempty" yaml:"from,omitempty"`
+}
+
+// ReplacementField is either an inline [Replacement] or the path of a file
+// containing one or more replacements.
+type ReplacementField struct {
+	Replacement `json:",inline,omitempty" yaml:",inline,omitempty"`
+	Path        string `json:"path,omitempty" yaml:"path,omitempty"`
+}
+
+// SourceSelector selects the source resource and the field containing the
+// replacement value.
+//
+// It mirrors [types.SourceSelector] with additional label and annotation
+// selectors. Its fields can reference the current target with
+// ${target.<field path>}, like ${target.metadata.name}. In this case, the
+// source is selected for each target.
+type SourceSelector struct {
+	types.SourceSelector `json:",inline" yaml:",inline"`
+
+	// Only select the resources matching this label selector.
+	LabelSelector string `json:"labelSelector,omitempty" yaml:"labelSelector,omitempty"`
+
+	// Only select the resources matching this annotation selector.
+	AnnotationSelector string `json:"annotationSelector,omitempty" yaml:"annotationSelector,omitempty"`
+}
+
+// targetReferenceRegexp matches the references to the target in a source
+// selector.
+var targetReferenceRegexp = regexp.MustCompile(`\$\{target\.([^}]+)\}`)
+
+// stringFields returns pointers to the fields of s that can reference the
+// target.
+func (s *SourceSelector) stringFields() []*string {
+	return []*string{
+		&s.Group, &s.Version, &s.Kind, &s.Name, &s.Namespace,
+		&s.FieldPath, &s.LabelSelector, &s.AnnotationSelector,
+	}
+}
+
+// isCorrelated returns true if s references the target.
+func (s *SourceSelector) isCorrelated() bool {
+	for _, field := range s.stringFields() {
+		if targetReferenceRegexp.MatchString(*field) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns a copy of s with the references to the target replaced by
+// the values of the target fields. If a referenced field doesn't exist in
+// target, an error wrapping [ErrNoMatch] is returned.
+func (s *SourceSelector) resolve(target *yaml.RNode) (*SourceSelector, error) {
+	result := *s
+	for _, field := range result.stringFields() {
+		var err error
+		*field = targetReferenceRegexp.ReplaceAllStringFunc(*field, func(reference string) string {
+			path := targetReferenceRegexp.FindStringSubmatch(reference)[1]
+			value, lookupErr := target.Pipe(yaml.Lookup(kyaml_utils.SmarterPathSplitter(path, ".")...))
+			switch {
+			case lookupErr != nil:
+				err = fmt.Errorf("while looking up target field %s: %w", path, lookupErr)
+			case value == nil || value.YNode().Kind != yaml.ScalarNode:
+				err = fmt.Errorf("%w: target field %s", ErrNoMatch, path)
+			default:
+				return value.YNode().Value
+			}
+			return reference
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &result, nil
+}
+
+// TargetSelector specifies fields in one or more objects.
+//
+// It mirrors [types.TargetSelector] with additional karmafun options.
+type TargetSelector struct {
+	// Include objects that match this.
+	Select *Selector `json:"select" yaml:"select"`
+
+	// From the allowed set, remove objects that match this.
+	Reject []*Selector `json:"reject,omitempty" yaml:"reject,omitempty"`
+
+	// Structured field paths expected in each allowed object.
+	FieldPaths []string `json:"fieldPaths,omitempty" yaml:"fieldPaths,omitempty"`
+
+	// Used to refine the interpretation of the field.
+	Options *FieldOptions `json:"options,omitempty" yaml:"options,omitempty"`
+
+	// If true, fail when the target selects no resource or when a field path
+	// matches nothing. Overrides the strict setting of the transformer.
+	Strict *bool `json:"strict,omitempty" yaml:"strict,omitempty"`
+
+	// Only replace the fields whose current value satisfies this condition.
+	When *TargetCondition `json:"when,omitempty" yaml:"when,omitempty"`
+}
+
+// readsContent returns true if the selection of the targets reads the
+// content of the resources beyond their metadata.
+func (t *TargetSelector) readsContent() bool {
+	if t.Select.FieldSelector != "" || t.Select.CELSelector != "" {
+		return true
+	}
+	for _, reject := range t.Reject {
+		if reject.FieldSelector != "" || reject.CELSelector != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// isStrict returns true if the target must match, defaulting to
+// defaultStrict when the target doesn't specify it.
+func (t *TargetSelector) isStrict(defaultStrict bool) bool {
+	if t.Strict == nil {
+		return defaultStrict
+	}
+	return *t.Strict
+}
+
+// TargetCondition is a condition on the current value of a target field.
+// When several conditions are specified, all of them must be satisfied.
+type TargetCondition struct {
+	// The current value must be equal to this value.
+	Equals *string `json:"equals,omitempty" yaml:"equals,omitempty"`
+
+	// The current value must match this regular expression.
+	Matches string `json:"matches,omitempty" yaml:"matches,omitempty"`
+
+	// The field must not exist. Cannot be combined with equals and matches.
+	Absent bool `json:"absent,omitempty" yaml:"absent,omitempty"`
+}
+
+// targetCondition is the compiled form of a [TargetCondition].
+type targetCondition struct {
+	equals  *string
+	matches *regexp.Regexp
+	absent  bool
+}
+
+// compile checks c and returns its compiled form, or nil if c is nil.
+func (c *TargetCondition) compile() (*targetCondition, error) {
+	if c == nil {
+		return nil, nil //nolint:nilnil // no condition
+	}
+	if c.Absent && (c.Equals != nil || c.Matches != "") {
+		return nil, fmt.Errorf("when.absent cannot be combined with when.equals or when.matches")
+	}
+	result := &targetCondition{equals: c.Equals, absent: c.Absent}
+	if c.Matches != "" {
+		re, err := regexp.Compile(c.Matches)
+		if err != nil {
+			return nil, fmt.Errorf("while compiling when.matches %s: %w", c.Matches, err)
+		}
+		result.matches = re
+	}
+	return result, nil
+}
+
+// holds returns true if the condition is satisfied by the current value of a
+// field. present is false if the field doesn't exist.
+func (c *targetCondition) holds(value string, present bool) bool {
+	if c.absent {
+		return !present
+	}
+	if !present {
+		return false
+	}
+	if c.equals != nil && value != *c.equals {
+		return false
+	}
+	return c.matches == nil || c.matches.MatchString(value)
+}
+
+// FieldOptions refine the interpretation of the target FieldPaths.
+//
+// On top of the [types.FieldOptions], it allows inserting the value in a
+// sequence instead of replacing the target field. At most one of Append,
+// Prepend, InsertAt and AppendIfMissing can be specified. Type allows
+// converting the value before writing it.
+type FieldOptions struct {
+	types.FieldOptions `json:",inline" yaml:",inline"`
+
+	// Append the value at the end of the target sequence.
+	Append bool `json:"append,omitempty" yaml:"append,omitempty"`
+
+	// Prepend the value at the beginning of the target sequence.
+	Prepend bool `json:"prepend,omitempty" yaml:"prepend,omitempty"`
+
+	// Insert the value at this index of the target sequence. Negative values
+	// count from the end of the sequence.
+	InsertAt *int `json:"insertAt,omitempty" yaml:"insertAt,omitempty"`
+
+	// Append the value at the end of the target sequence if it is not
+	// already present.
+	AppendIfMissing bool `json:"appendIfMissing,omitempty" yaml:"appendIfMissing,omitempty"`
+
+	// Field used to find duplicate mapping elements in the target sequence.
+	// When set, values already present in the sequence are not inserted. If
+	// empty, appendIfMissing compares elements by value.
+	MergeKey string `json:"mergeKey,omitempty" yaml:"mergeKey,omitempty"`
+
+	// Type of the value written in the target: string, bool, int, float or
+	// auto. When set, the value is written with this type instead of keeping
+	// the type of the field it replaces.
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+
+	// Write the value as is in the data of a Secret or the binaryData of a
+	// ConfigMap instead of base64 encoding it.
+	Raw bool `json:"raw,omitempty" yaml:"raw,omitempty"`
+
+	// Rename the key of the target field with the value instead of replacing
+	// the field value. The field value is moved with its subtree and comments.
+	RenameKey bool `json:"renameKey,omitempty" yaml:"renameKey,omitempty"`
+
+	// With RenameKey, the value is the path where the target field is moved
+	// instead of its new key. The path starts at the root of the resource, or
+	// of the embedded document for extended paths.
+	KeyPath bool `json:"keyPath,omitempty" yaml:"keyPath,omitempty"`
+}
+
+// isRenameKey returns true if the value renames the target key.
+func (o *FieldOptions) isRenameKey() bool {
+	return o != nil && o.RenameKey
+}
+
+// isRaw returns true if the value must not be base64 encoded in Secret data
+// and ConfigMap binaryData fields.
+func (o *FieldOptions) isRaw() bool {
+	return o != nil && o.Raw
+}
+
+// sequenceOptions returns the sequence insertion options corresponding to o,
+// or nil if the value is not inserted in a sequence.
+func (o *FieldOptions) sequenceOptions() (*SequenceOptions, error) {
+	if o == nil {
+		return nil, nil //nolint:nilnil // no options means no insertion
+	}
+	var result *SequenceOptions
+	count := 0
+	if o.Append {
+		result = &SequenceOptions{Index: -1}
+		count++
+	}
+	if o.Prepend {
+		result = &SequenceOptions{Index: 0}
+		count++
+	}
+	if o.InsertAt != nil {
+		result = &SequenceOptions{Index: *o.InsertAt}
+		count++
+	}
+	if o.AppendIfMissing {
+		result = &SequenceOptions{Index: -1, Unique: true}
+		count++
+	}
+	if count > 1 {
+		return nil, fmt.Errorf("only one of append, prepend, insertAt and appendIfMissing can be specified")
+	}
+	if result != nil {
+		result.MergeKey = o.MergeKey
+		if o.MergeKey != "" {
+			result.Unique = true
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,404 @@
+package extras
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	kyaml_utils "sigs.k8s.io/kustomize/kyaml/utils"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// Selector specifies a set of resources.
+//
+// It mirrors [types.Selector] with additional selectors on the content of the
+// resources. A resource is selected if it matches all the specified selectors.
+type Selector struct {
+	types.Selector `json:",inline" yaml:",inline"`
+
+	// FieldSelector is a comma separated list of requirements on the fields
+	// of the resource. Each requirement has one of the following forms:
+	//
+	//   - path=value or path==value: the field is equal to value.
+	//   - path!=value: the field is absent or different from value.
+	//   - path~=regex: the field matches the regular expression.
+	//   - path: the field exists.
+	//   - !path: the field doesn't exist.
+	//
+	// A comma can be escaped with a backslash.
+	FieldSelector string `json:"fieldSelector,omitempty" yaml:"fieldSelector,omitempty"`
+
+	// CELSelector is a CEL expression returning a boolean. The resource is
+	// available in the expression as the resource variable.
+	CELSelector string `json:"celSelector,omitempty" yaml:"celSelector,omitempty"`
+
+	// PathSelector is a glob pattern on the path of the file the resource
+	// comes from, as recorded in the config.kubernetes.io/path annotation.
+	// Besides the usual wildcards, ** matches any number of directories.
+	PathSelector string `json:"pathSelector,omitempty" yaml:"pathSelector,omitempty"`
+
+	// fieldRequirements are the parsed requirements of the field selector.
+	fieldRequirements []*fieldRequirement
+	// pathRegexp is the compiled path selector.
+	pathRegexp *regexp.Regexp
+	// celProgram is the compiled CEL selector.
+	celProgram cel.Program
+}
+
+// compile parses the field selector and compiles the path and CEL selectors
+// of s if not already done. The plugins
+// compile their selectors when configured so that invalid selectors are
+// reported before any resource is processed.
+func (s *Selector) compile() error {
+	if s.FieldSelector != "" && s.fieldRequirements == nil {
+		requirements, err := parseFieldSelector(s.FieldSelector)
+		if err != nil {
+			return err
+		}
+		s.fieldRequirements = requirements
+	}
+	if s.PathSelector != "" && s.pathRegexp == nil {
+		re, err := globRegexp(s.PathSelector)
+		if err != nil {
+			return err
+		}
+		s.pathRegexp = re
+	}
+	if s.CELSelector != "" && s.celProgram == nil {
+		program, err := compileCELSelector(s.CELSelector)
+		if err != nil {
+			return err
+		}
+		s.celProgram = program
+	}
+	return nil
+}
+
+// compileSelectors compiles the selectors that are not nil.
+func compileSelectors(selectors []*Selector) error {
+	for _, s := range selectors {
+		if s == nil {
+			continue
+		}
+		if err := s.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasContentSelectors returns true if s selects resources by their content.
+func (s *Selector) hasContentSelectors() bool {
+	return s.AnnotationSelector != "" || s.LabelSelector != "" ||
+		s.FieldSelector != "" || s.CELSelector != "" || s.PathSelector != ""
+}
+
+// matchesContent returns true if n matches the label, annotation, path,
+// field and CEL selectors of s.
+func (s *Selector) matchesContent(n *yaml.RNode) (bool, error) {
+	reason, err := s.explainContent(n)
+	return reason == "" && err == nil, err
+}
+
+// explainContent returns why n doesn't match the label, annotation, path,
+// field and CEL selectors of s, or an empty string if it matches them.
+func (s *Selector) explainContent(n *yaml.RNode) (string, error) {
+	if err := s.compile(); err != nil {
+		return "", err
+	}
+	checks := []struct {
+		name     string
+		selector string
+		matches  func() (bool, error)
+	}{
+		{"annotationSelector", s.AnnotationSelector, func() (bool, error) {
+			return matchesAnnoAndLabelSelector(n, &types.Selector{AnnotationSelector: s.AnnotationSelector})
+		}},
+		{"labelSelector", s.LabelSelector, func() (bool, error) {
+			return matchesAnnoAndLabelSelector(n, &types.Selector{LabelSelector: s.LabelSelector})
+		}},
+		{"pathSelector", s.PathSelector, func() (bool, error) { return matchesPathSelector(n, s.pathRegexp) }},
+		{"fieldSelector", s.FieldSelector, func() (bool, error) { return matchesFieldSelector(n, s.fieldRequirements) }},
+		{"celSelector", s.CELSelector, func() (bool, error) { return matchesCELSelector(n, s.CELSelector, s.celProgram) }},
+	}
+	for _, check := range checks {
+		if check.selector == "" {
+			continue
+		}
+		matches, err := check.matches()
+		if err != nil {
+			return "", err
+		}
+		if !matches {
+			return fmt.Sprintf("%s %q doesn't match", check.name, check.selector), nil
+		}
+	}
+	return "", nil
+}
+
+// String returns a string representation of the selector.
+func (s *Selector) String() string {
+	result := s.Selector.String()
+	if s.FieldSelector != "" {
+		result = fmt.Sprintf("%s field:%s", result, s.FieldSelector)
+	}
+	if s.CELSelector != "" {
+		result = fmt.Sprintf("%s cel:%s", result, s.CELSelector)
+	}
+	if s.PathSelector != "" {
+		result = fmt.Sprintf("%s path:%s", result, s.PathSelector)
+	}
+	return result
+}
+
+// Field selector operators.
+const (
+	fieldOperatorEquals      = "="
+	fieldOperatorEqualsEqual = "=="
+	fieldOperatorNotEquals   = "!="
+	fieldOperatorMatches     = "~="
+	fieldOperatorExists      = ""
+	fieldOperatorNotExists   = "!"
+)
+
+// fieldRequirement is a requirement of a field selector.
+type fieldRequirement struct {
+	path     []string
+	operator string
+	value    string
+	regex    *regexp.Regexp
+}
+
+// splitFieldSelector splits the field selector on the commas that are not
+// escaped.
+func splitFieldSelector(selector string) []string {
+	var result []string
+	var current strings.Builder
+	for i := 0; i < len(selector); i++ {
+		switch {
+		case selector[i] == '\\' && i+1 < len(selector) && selector[i+1] == ',':
+			current.WriteByte(',')
+			i++
+		case selector[i] == ',':
+			result = append(result, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(selector[i])
+		}
+	}
+	return append(result, current.String())
+}
+
+// parseFieldRequirement parses one requirement of a field selector. The
+// operator is looked for outside of the brackets of the path, so that paths
+// like spec.containers.[name=app].image can be used.
+func parseFieldRequirement(requirement string) (*fieldRequirement, error) {
+	requirement = strings.TrimSpace(requirement)
+	if requirement == "" {
+		return nil, fmt.Errorf("empty field selector requirement")
+	}
+	depth := 0
+	for i := 0; i < len(requirement); i++ {
+		switch requirement[i] {
+		case '[':
+			depth++
+			continue
+		case ']':
+			depth--
+			continue
+		}
+		if depth > 0 {
+			continue
+		}
+		for _, operator := range []string{
+			fieldOperatorEqualsEqual, fieldOperatorNotEquals, fieldOperatorMatches, fieldOperatorEquals,
+		} {
+			if !strings.HasPrefix(requirement[i:], operator) {
+				continue
+			}
+			result := &fieldRequirement{
+				path:     kyaml_utils.SmarterPathSplitter(strings.TrimSpace(requirement[:i]), "."),
+				operator: operator,
+				value:    strings.TrimSpace(requirement[i+len(operator):]),
+			}
+			if operator == fieldOperatorMatches {
+				re, err := regexp.Compile(result.value)
+				if err != nil {
+					return nil, fmt.Errorf("while compiling field selector regex %s: %w", result.value, err)
+				}
+				result.regex = re
+			}
+			return result, nil
+		}
+	}
+	if fieldPath, ok := strings.CutPrefix(requirement, fieldOperatorNotExists); ok {
+		return &fieldRequirement{
+			path:     kyaml_utils.SmarterPathSplitter(fieldPath, "."),
+			operator: fieldOperatorNotExists,
+		}, nil
+	}
+	return &fieldRequirement{path: kyaml_utils.SmarterPathSplitter(requirement, "."), operator: fieldOperatorExists}, nil
+}
+
+// matches returns true if n satisfies the requirement.
+func (r *fieldRequirement) matches(n *yaml.RNode) (bool, error) {
+	field, err := n.Pipe(yaml.Lookup(r.path...))
+	if err != nil {
+		return false, fmt.Errorf("while looking up field %s: %w", strings.Join(r.path, "."), err)
+	}
+	present := !yaml.IsMissingOrNull(field)
+	value := ""
+	if present {
+		if field.YNode().Kind == yaml.ScalarNode {
+			value = field.YNode().Value
+		} else {
+			value = strings.TrimSuffix(field.MustString(), "\n")
+		}
+	}
+	switch r.operator {
+	case fieldOperatorExists:
+		return present, nil
+	case fieldOperatorNotExists:
+		return !present, nil
+	case fieldOperatorNotEquals:
+		return !present || value != r.value, nil
+	case fieldOperatorMatches:
+		return present && r.regex.MatchString(value), nil
+	}
+	return present && value == r.value, nil
+}
+
+// parseFieldSelector returns the requirements of the field selector.
+func parseFieldSelector(selector string) ([]*fieldRequirement, error) {
+	var result []*fieldRequirement
+	for _, requirement := range splitFieldSelector(selector) {
+		r, err := parseFieldRequirement(requirement)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing field selector %s: %w", selector, err)
+		}
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+// matchesFieldSelector returns true if n satisfies all the requirements of
+// a field selector.
+func matchesFieldSelector(n *yaml.RNode, requirements []*fieldRequirement) (bool, error) {
+	for _, r := range requirements {
+		matches, err := r.matches(n)
+		if !matches || err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// cleanSelectorPath returns p with forward slashes and without a leading ./.
+func cleanSelectorPath(p string) string {
+	return path.Clean(filepath.ToSlash(p))
+}
+
+// globRegexp returns the regular expression equivalent to the glob pattern.
+// * and ? don't match slashes while ** matches any number of directories.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	glob := cleanSelectorPath(pattern)
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					// **/ matches zero or more directories
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+				continue
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class in path selector %s", pattern)
+			}
+			class := glob[i+1 : i+end]
+			if negated, ok := strings.CutPrefix(class, "!"); ok {
+				class = "^" + negated
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("while compiling path selector %s: %w", pattern, err)
+	}
+	return re, nil
+}
+
+// matchesPathSelector returns true if the path of the file n comes from
+// matches re, the compiled glob pattern. Resources without a path are not
+// selected.
+func matchesPathSelector(n *yaml.RNode, re *regexp.Regexp) (bool, error) {
+	resourcePath, _, err := kioutil.GetFileAnnotations(n)
+	if err != nil {
+		return false, fmt.Errorf("while getting resource path: %w", err)
+	}
+	if resourcePath == "" {
+		return false, nil
+	}
+	return re.MatchString(cleanSelectorPath(resourcePath)), nil
+}
+
+// compileCELSelector returns the compiled program of the CEL expression.
+func compileCELSelector(expression string) (cel.Program, error) {
+	env, err := cel.NewEnv(cel.Variable("resource", cel.DynType))
+	if err != nil {
+		return nil, fmt.Errorf("while creating CEL environment: %w", err)
+	}
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("while compiling CEL selector %s: %w", expression, issues.Err())
+	}
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return nil, fmt.Errorf("CEL selector %s must return a boolean, not %s", expression, ast.OutputType())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("while creating CEL program for %s: %w", expression, err)
+	}
+	return program, nil
+}
+
+// matchesCELSelector returns true if program, the compiled CEL expression,
+// evaluates to true for n. A resource for which the evaluation fails, for
+// instance because it lacks a field used by the expression, is not selected.
+func matchesCELSelector(n *yaml.RNode, expression string, program cel.Program) (bool, error) {
+	resource, err := n.Map()
+	if err != nil {
+		return false, fmt.Errorf("while converting resource for CEL: %w", err)
+	}
+	out, _, err := program.Eval(map[string]any{"resource": resource})
+	if err != nil {
+		return false, nil //nolint:nilerr // resources not fitting the expression are not selected
+	}
+	result, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("CEL selector %s returned %v instead of a boolean", expression, out.Value())
+	}
+	return result, nil
+}
@@ -0,0 +1,246 @@
+package extras_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func TestReplacementContentSelectors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		selector  string
+		reject    string
+		revisions []string
+		wantErr   string
+	}{
+		{
+			name:      "field equals",
+			selector:  "fieldSelector: metadata.labels.team=alpha",
+			revisions: []string{"selected", "main", "main"},
+		},
+		{
+			name:      "field not equals",
+			selector:  "fieldSelector: metadata.labels.team!=alpha",
+			revisions: []string{"main", "selected", "selected"},
+		},
+		{
+			name:      "field matches",
+			selector:  "fieldSelector: 'metadata.name~=^app-[ab]$'",
+			revisions: []string{"selected", "selected", "main"},
+		},
+		{
+			name:      "field exists",
+			selector:  "fieldSelector: metadata.labels.team",
+			revisions: []string{"selected", "selected", "main"},
+		},
+		{
+			name:      "field does not exist",
+			selector:  "fieldSelector: '!metadata.labels.team'",
+			revisions: []string{"main", "main", "selected"},
+		},
+		{
+			name:      "several field requirements",
+			selector:  "fieldSelector: 'metadata.labels.team,metadata.name!=app-a'",
+			revisions: []string{"main", "selected", "main"},
+		},
+		{
+			name:      "field reject",
+			reject:    "fieldSelector: metadata.labels.team=beta",
+			revisions: []string{"selected", "main", "selected"},
+		},
+		{
+			name:      "cel",
+			selector:  "celSelector: resource.metadata.name == 'app-c'",
+			revisions: []string{"main", "main", "selected"},
+		},
+		{
+			name:      "cel on missing field",
+			selector:  "celSelector: resource.metadata.labels.team == 'beta'",
+			revisions: []string{"main", "selected", "main"},
+		},
+		{
+			name:      "cel reject",
+			reject:    "celSelector: \"has(resource.metadata.labels) && resource.metadata.labels.team == 'alpha'\"",
+			revisions: []string{"main", "selected", "selected"},
+		},
+		{
+			name:     "invalid regex",
+			selector: "fieldSelector: 'metadata.name~=['",
+			wantErr:  "while compiling field selector regex",
+		},
+		{
+			name:     "invalid cel",
+			selector: "celSelector: resource.(",
+			wantErr:  "while compiling CEL selector",
+		},
+		{
+			name:     "cel not returning a boolean",
+			selector: "celSelector: resource.metadata.name",
+			wantErr:  "instead of a boolean",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := require.New(t)
+			selector := "{kind: Application}"
+			if tt.selector != "" {
+				selector = fmt.Sprintf("{kind: Application, %s}", tt.selector)
+			}
+			reject := "[]"
+			if tt.reject != "" {
+				reject = fmt.Sprintf("[{%s}]", tt.reject)
+			}
+			config := fmt.Sprintf(`
+replacements:
+  - sourceValue: selected
+    targets:
+      - select: %s
+        reject: %s
+        fieldPaths:
+          - spec.source.targetRevision
+`, selector, reject)
+			got, _, err := runReplacementTransformer(t, config, correlatedResources)
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			nodes, err := kio.FromBytes([]byte(got))
+			req.NoError(err)
+			var revisions []string
+			for _, n := range nodes {
+				if n.GetKind() == "Application" {
+					revision, lookupErr := n.Pipe(yaml.Lookup("spec", "source", "targetRevision"))
+					req.NoError(lookupErr)
+					revisions = append(revisions, yaml.GetValue(revision))
+				}
+			}
+			req.Equal(tt.revisions, revisions)
+		})
+	}
+}
+
+const pathResources = `apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: app-a
+  annotations:
+    config.kubernetes.io/path: applications/prod/app-a.yaml
+spec:
+  source:
+    targetRevision: main
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: app-b
+  annotations:
+    config.kubernetes.io/path: ./applications/prod/eu/app-b.yaml
+spec:
+  source:
+    targetRevision: main
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: app-c
+  annotations:
+    config.kubernetes.io/path: applications/dev/app-c.yaml
+spec:
+  source:
+    targetRevision: main
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: app-d
+spec:
+  source:
+    targetRevision: main
+`
+
+func TestReplacementPathSelector(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		selector  string
+		reject    string
+		revisions []string
+		wantErr   string
+	}{
+		{
+			name:      "recursive",
+			selector:  "applications/prod/**",
+			revisions: []string{"selected", "selected", "main", "main"},
+		},
+		{
+			name:      "single level",
+			selector:  "applications/*/*.yaml",
+			revisions: []string{"selected", "main", "selected", "main"},
+		},
+		{
+			name:      "single file",
+			selector:  "./applications/prod/eu/app-b.yaml",
+			revisions: []string{"main", "selected", "main", "main"},
+		},
+		{
+			name:      "any directory",
+			selector:  "**/app-[ac].yaml",
+			revisions: []string{"selected", "main", "selected", "main"},
+		},
+		{
+			name:      "reject",
+			reject:    "applications/prod/**",
+			revisions: []string{"main", "main", "selected", "selected"},
+		},
+		{
+			name:     "invalid pattern",
+			selector: "applications/[prod",
+			wantErr:  "unterminated character class",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := require.New(t)
+			selector := "{kind: Application}"
+			if tt.selector != "" {
+				selector = fmt.Sprintf("{kind: Application, pathSelector: '%s'}", tt.selector)
+			}
+			reject := "[]"
+			if tt.reject != "" {
+				reject = fmt.Sprintf("[{pathSelector: '%s'}]", tt.reject)
+			}
+			config := fmt.Sprintf(`
+replacements:
+  - sourceValue: selected
+    targets:
+      - select: %s
+        reject: %s
+        fieldPaths:
+          - spec.source.targetRevision
+`, selector, reject)
+			got, _, err := runReplacementTransformer(t, config, pathResources)
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			nodes, err := kio.FromBytes([]byte(got))
+			req.NoError(err)
+			var revisions []string
+			for _, n := range nodes {
+				revision, lookupErr := n.Pipe(yaml.Lookup("spec", "source", "targetRevision"))
+				req.NoError(lookupErr)
+				revisions = append(revisions, yaml.GetValue(revision))
+			}
+			req.Equal(tt.revisions, revisions)
+		})
+	}
+}
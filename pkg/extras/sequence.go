@@ -0,0 +1,174 @@
+package extras
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// appendPathElement is the last element of an extended path that appends
+	// the value to the sequence designated by the rest of the path.
+	appendPathElement = "-"
+	// prependPathElement is the last element of an extended path that
+	// prepends the value to the sequence designated by the rest of the path.
+	prependPathElement = "+"
+)
+
+// SequenceOptions specifies how a value is inserted in a sequence.
+type SequenceOptions struct {
+	// Index is the position of the inserted value. Negative values count from
+	// the end of the sequence: -1 appends the value.
+	Index int
+	// Unique prevents the insertion of a value that is already present in the
+	// sequence.
+	Unique bool
+	// MergeKey is the name of the field used to compare mapping elements
+	// when Unique is true. If empty, elements are compared by value.
+	MergeKey string
+}
+
+// position returns the insertion position in a sequence of length l.
+func (o *SequenceOptions) position(l int) int {
+	pos := o.Index
+	if pos < 0 {
+		pos = l + 1 + pos
+	}
+	return max(0, min(pos, l))
+}
+
+// sequenceOptionsFromPath returns the path of the sequence and the insertion
+// options if the last element of path is an append or a prepend element.
+// Otherwise, it returns nil options.
+func sequenceOptionsFromPath(path []string) ([]string, *SequenceOptions) {
+	if len(path) == 0 {
+		return path, nil
+	}
+	switch path[len(path)-1] {
+	case appendPathElement:
+		return path[:len(path)-1], &SequenceOptions{Index: -1}
+	case prependPathElement:
+		return path[:len(path)-1], &SequenceOptions{Index: 0}
+	}
+	return path, nil
+}
+
+// sameElement returns true if a and b are the same element of a sequence.
+//
+// With a merge key, mapping elements are the same if they have the same value
+// for the key. Otherwise, elements are compared by value.
+func sameElement(a, b *yaml.Node, mergeKey string) bool {
+	if mergeKey != "" && a.Kind == yaml.MappingNode && b.Kind == yaml.MappingNode {
+		aKey, bKey := yaml.NewRNode(a).Field(mergeKey), yaml.NewRNode(b).Field(mergeKey)
+		if aKey != nil && bKey != nil {
+			return yaml.GetValue(aKey.Value) == yaml.GetValue(bKey.Value)
+		}
+	}
+	if a.Kind == yaml.ScalarNode && b.Kind == yaml.ScalarNode {
+		return a.Value == b.Value
+	}
+	aString, aErr := yaml.NewRNode(a).String()
+	bString, bErr := yaml.NewRNode(b).String()
+	return aErr == nil && bErr == nil && aString == bString
+}
+
+// insertInSequence inserts value in the sequence node seq according to
+// options. If value is itself a sequence, its elements are inserted.
+func insertInSequence(seq *yaml.RNode, value *yaml.Node, options *SequenceOptions) error {
+	if seq.YNode().Kind != yaml.SequenceNode {
+		return fmt.Errorf("cannot insert in node of kind %s: not a sequence", seq.YNode().ShortTag())
+	}
+	blockStyleIfEmpty(seq.YNode())
+	elements := []*yaml.Node{value}
+	if value.Kind == yaml.SequenceNode {
+		elements = value.Content
+	}
+
+	content := seq.YNode().Content
+	pos := options.position(len(content))
+	var inserted []*yaml.Node
+	for _, element := range elements {
+		if options.Unique && containsElement(content, element, options.MergeKey) {
+			continue
+		}
+		inserted = append(inserted, element)
+	}
+	result := make([]*yaml.Node, 0, len(content)+len(inserted))
+	result = append(result, content[:pos]...)
+	result = append(result, inserted...)
+	result = append(result, content[pos:]...)
+	seq.YNode().Content = result
+	return nil
+}
+
+// containsElement returns true if content contains element.
+func containsElement(content []*yaml.Node, element *yaml.Node, mergeKey string) bool {
+	for _, e := range content {
+		if sameElement(e, element, mergeKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// insertValue inserts value in the sequence at path in node, creating the
+// sequence if needed.
+func insertValue(node *yaml.RNode, path []string, value any, options *SequenceOptions) error {
+	seq, err := Lookup(node, path, yaml.SequenceNode)
+	if err != nil {
+		return fmt.Errorf("error fetching sequence in replacement target: %w", err)
+	}
+	element, ok := value.(*yaml.Node)
+	if typed, isTyped := value.(*TypedValue); isTyped {
+		copied := *typed.Node
+		element, ok = &copied, true
+	}
+	if !ok {
+		element = &yaml.Node{Kind: yaml.ScalarNode, Tag: yaml.NodeTagString, Value: string(getByteValue(value))}
+	}
+	return insertInSequence(seq, element, options)
+}
+
+// blockStyleIfEmpty switches node to the block style if it is an empty flow
+// sequence, like `parameters: []`, so that elements added to it don't end up
+// in flow style.
+func blockStyleIfEmpty(node *yaml.Node) {
+	if node.Kind == yaml.SequenceNode && len(node.Content) == 0 {
+		node.Style &^= yaml.FlowStyle
+	}
+}
+
+// prepareCreation switches the empty flow sequences found along path in node
+// to the block style before the missing elements of path are created.
+func prepareCreation(node *yaml.RNode, path []string) {
+	for i := range path {
+		current, err := node.Pipe(&yaml.PathGetter{Path: path[:i+1]})
+		if err != nil || current == nil {
+			return
+		}
+		blockStyleIfEmpty(current.YNode())
+	}
+}
+
+// setKeyField makes sure that node contains the key field of the keyed list
+// element, like [name=common.repoURL], ending path. This keeps the element
+// addressable when its content is replaced by a mapping value.
+func setKeyField(node *yaml.RNode, path []string) error {
+	if len(path) == 0 || node.YNode().Kind != yaml.MappingNode || !yaml.IsListIndex(path[len(path)-1]) {
+		return nil
+	}
+	name, value, err := yaml.SplitIndexNameValue(path[len(path)-1])
+	if err != nil {
+		return fmt.Errorf("while getting key of element %s: %w", path[len(path)-1], err)
+	}
+	if name == "" {
+		return nil
+	}
+	if field := node.Field(name); field != nil {
+		field.Value.YNode().Value = value
+		return nil
+	}
+	key := &yaml.Node{Kind: yaml.ScalarNode, Value: name}
+	node.YNode().Content = append([]*yaml.Node{key, yaml.NewScalarRNode(value).YNode()}, node.YNode().Content...)
+	return nil
+}
@@ -0,0 +1,320 @@
+package extras_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const sequenceResources = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: configuration-map
+data:
+  syncOption: ServerSideApply=true
+  existingSyncOption: CreateNamespace=true
+  host: new.example.com
+  parameter: |
+    name: common.repoURL
+    value: https://github.com/karmafun/karmafun.git
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: app
+spec:
+  source:
+    helm:
+      parameters:
+      - name: common.repoURL
+        value: https://github.com/upstream/app.git
+      values: |
+        hosts:
+        - old.example.com
+  syncPolicy:
+    syncOptions:
+    - CreateNamespace=true
+`
+
+func TestReplacementSequenceInsertion(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		source  string
+		path    string
+		options string
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "append",
+			source:  "data.syncOption",
+			path:    "spec.syncPolicy.syncOptions",
+			options: "{append: true}",
+			want: `
+    syncOptions:
+    - CreateNamespace=true
+    - ServerSideApply=true
+`,
+		},
+		{
+			name:    "prepend",
+			source:  "data.syncOption",
+			path:    "spec.syncPolicy.syncOptions",
+			options: "{prepend: true}",
+			want: `
+    syncOptions:
+    - ServerSideApply=true
+    - CreateNamespace=true
+`,
+		},
+		{
+			name:    "insert at negative index",
+			source:  "data.syncOption",
+			path:    "spec.syncPolicy.syncOptions",
+			options: "{insertAt: -2}",
+			want: `
+    syncOptions:
+    - ServerSideApply=true
+    - CreateNamespace=true
+`,
+		},
+		{
+			name:    "append if missing by value",
+			source:  "data.syncOption",
+			path:    "spec.syncPolicy.syncOptions",
+			options: "{appendIfMissing: true}",
+			want: `
+    syncOptions:
+    - CreateNamespace=true
+    - ServerSideApply=true
+`,
+		},
+		{
+			name:    "append if missing with existing value",
+			source:  "data.existingSyncOption",
+			path:    "spec.syncPolicy.syncOptions",
+			options: "{appendIfMissing: true}",
+			want: `
+    syncOptions:
+    - CreateNamespace=true
+`,
+		},
+		{
+			name:    "create missing sequence",
+			source:  "data.syncOption",
+			path:    "spec.syncPolicy.managedNamespaceMetadata.syncOptions",
+			options: "{append: true, create: true}",
+			want: `
+    managedNamespaceMetadata:
+      syncOptions:
+      - ServerSideApply=true
+`,
+		},
+		{
+			name:    "append in embedded yaml with path syntax",
+			source:  "data.host",
+			path:    "spec.source.helm.values.!!yaml.hosts.-",
+			options: "{}",
+			want: `
+        hosts:
+        - old.example.com
+        - new.example.com
+`,
+		},
+		{
+			name:    "prepend in embedded yaml with path syntax",
+			source:  "data.host",
+			path:    "spec.source.helm.values.!!yaml.hosts.+",
+			options: "{}",
+			want: `
+        hosts:
+        - new.example.com
+        - old.example.com
+`,
+		},
+		{
+			name:    "append in embedded yaml with options",
+			source:  "data.host",
+			path:    "spec.source.helm.values.!!yaml.hosts",
+			options: "{append: true}",
+			want: `
+        hosts:
+        - old.example.com
+        - new.example.com
+`,
+		},
+		{
+			name:    "several insertion options",
+			source:  "data.syncOption",
+			path:    "spec.syncPolicy.syncOptions",
+			options: "{append: true, prepend: true}",
+			wantErr: "only one of",
+		},
+		{
+			name:    "insertion in a mapping",
+			source:  "data.syncOption",
+			path:    "spec.syncPolicy",
+			options: "{append: true}",
+			wantErr: "not a sequence",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := require.New(t)
+			config := fmt.Sprintf(`
+replacements:
+  - source:
+      kind: ConfigMap
+      fieldPath: %s
+    targets:
+      - select:
+          kind: Application
+        fieldPaths:
+          - %s
+        options: %s
+`, tt.source, tt.path, tt.options)
+			got, _, err := runReplacementTransformer(t, config, sequenceResources)
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			req.Contains(got, tt.want[1:])
+		})
+	}
+}
+
+func TestReplacementSequenceMergeKey(t *testing.T) {
+	t.Parallel()
+	req := require.New(t)
+	config := `
+replacements:
+  - source:
+      kind: ConfigMap
+      fieldPath: data.parameter
+    targets:
+      - select:
+          kind: Application
+        fieldPaths:
+          - spec.source.helm.parameters
+        options:
+          appendIfMissing: true
+          mergeKey: name
+`
+	input := strings.Replace(sequenceResources, `  parameter: |
+    name: common.repoURL
+    value: https://github.com/karmafun/karmafun.git
+`, `  parameter:
+    name: common.repoURL
+    value: https://github.com/karmafun/karmafun.git
+`, 1)
+	got, _, err := runReplacementTransformer(t, config, input)
+	req.NoError(err)
+	req.Contains(got, `
+      parameters:
+      - name: common.repoURL
+        value: https://github.com/upstream/app.git
+      values: |
+`, "the parameter should not be appended twice")
+}
+
+const keyedResources = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: configuration-map
+data:
+  repoURL: https://github.com/karmafun/karmafun.git
+  container:
+    image: nginx:1.27
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: app
+spec:
+  source:
+    helm:
+      parameters: []
+      values: |
+        env: []
+  template:
+    spec:
+      containers:
+      - name: sidecar
+        image: busybox
+`
+
+func TestReplacementKeyedElementCreation(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		source string
+		path   string
+		want   string
+	}{
+		{
+			name:   "helm parameter in empty flow sequence",
+			source: "data.repoURL",
+			path:   "spec.source.helm.parameters.[name=common.repoURL].value",
+			want: `
+      parameters:
+      - name: common.repoURL
+        value: https://github.com/karmafun/karmafun.git
+`,
+		},
+		{
+			name:   "container upsert with a mapping value",
+			source: "data.container",
+			path:   "spec.template.spec.containers.[name=app]",
+			want: `
+      containers:
+      - name: sidecar
+        image: busybox
+      - name: app
+        image: nginx:1.27
+`,
+		},
+		{
+			name:   "env variable in embedded yaml",
+			source: "data.repoURL",
+			path:   "spec.source.helm.values.!!yaml.env.[name=REPO_URL].value",
+			want: `
+      values: |
+        env:
+        - name: REPO_URL
+          value: https://github.com/karmafun/karmafun.git
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := require.New(t)
+			config := fmt.Sprintf(`
+replacements:
+  - source:
+      kind: ConfigMap
+      fieldPath: %s
+    targets:
+      - select:
+          kind: Application
+        fieldPaths:
+          - %s
+        options:
+          create: true
+`, tt.source, tt.path)
+			got, _, err := runReplacementTransformer(t, config, keyedResources)
+			req.NoError(err)
+			req.Contains(got, tt.want[1:])
+
+			// Running the replacement again should not change anything.
+			again, _, err := runReplacementTransformer(t, config, got)
+			req.NoError(err)
+			req.Equal(got, again, "replacement should be idempotent")
+		})
+	}
+}
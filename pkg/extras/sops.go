@@ -0,0 +1,116 @@
+package extras
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/karmafun/karmafun/pkg/utils"
+)
+
+// sopsMetadataField is the top level field containing the sops metadata of
+// an encrypted resource.
+const sopsMetadataField = "sops"
+
+// isSopsEncrypted returns true if n is a sops encrypted resource.
+func isSopsEncrypted(n *yaml.RNode) bool {
+	metadata := n.Field(sopsMetadataField)
+	return metadata != nil && metadata.Value.YNode().Kind == yaml.MappingNode
+}
+
+// isOrchestratorAnnotation returns true if key is an annotation added to the
+// resources by kustomize or the function orchestrator, like the path of the
+// resource file.
+func isOrchestratorAnnotation(key string) bool {
+	switch key {
+	case kioutil.LegacyPathAnnotation, kioutil.LegacyIndexAnnotation, kioutil.LegacyIdAnnotation: //nolint:staticcheck // still in use.
+		return true
+	}
+	return strings.HasPrefix(key, "internal."+utils.ConfigurationAnnotationDomain+"/")
+}
+
+// isTransientAnnotation returns true if key is an annotation added to the
+// resources by kustomize, the function orchestrator or karmafun. These
+// annotations are not part of the resource files and are kept out of the
+// sops encrypted content and its message authentication code.
+func isTransientAnnotation(key string) bool {
+	return isOrchestratorAnnotation(key) || key == utils.FunctionAnnotationFunction ||
+		strings.HasPrefix(key, utils.LocalConfigurationAnnotationDomain+"/")
+}
+
+// stripTransientAnnotations removes the transient annotations of n and
+// returns them. The annotations field is removed if it becomes empty.
+func stripTransientAnnotations(n *yaml.RNode) (map[string]string, error) {
+	return stripAnnotations(n, isTransientAnnotation)
+}
+
+// stripAnnotations removes the annotations of n whose key matches and returns
+// them. The annotations field is removed if it becomes empty.
+func stripAnnotations(n *yaml.RNode, matches func(key string) bool) (map[string]string, error) {
+	stripped := map[string]string{}
+	for key, value := range n.GetAnnotations() {
+		if !matches(key) {
+			continue
+		}
+		stripped[key] = value
+		if err := n.PipeE(yaml.ClearAnnotation(key)); err != nil {
+			return nil, fmt.Errorf("while removing annotation %s: %w", key, err)
+		}
+	}
+	if len(stripped) > 0 {
+		if err := yaml.ClearEmptyAnnotations(n); err != nil {
+			return nil, fmt.Errorf("while removing empty annotations: %w", err)
+		}
+	}
+	return stripped, nil
+}
+
+// heldFileAnnotations are the karmafun annotations holding the path and
+// index of a resource, followed by the annotations of the function
+// orchestrator they replace.
+var heldFileAnnotations = [][]string{
+	{utils.FunctionAnnotationPath, kioutil.PathAnnotation, kioutil.LegacyPathAnnotation},    //nolint:staticcheck // still in use.
+	{utils.FunctionAnnotationIndex, kioutil.IndexAnnotation, kioutil.LegacyIndexAnnotation}, //nolint:staticcheck // still in use.
+}
+
+// holdFileAnnotations moves the path and index annotations of the function
+// orchestrator to the karmafun ones, like for the local resources generated
+// in place, so that the orchestrator doesn't write the resource back to its
+// file.
+func holdFileAnnotations(annotations map[string]string) {
+	for _, keys := range heldFileAnnotations {
+		for _, key := range keys[1:] {
+			if value, ok := annotations[key]; ok {
+				annotations[keys[0]] = value
+				delete(annotations, key)
+			}
+		}
+	}
+}
+
+// releaseFileAnnotations moves back the path and index annotations held by
+// [holdFileAnnotations].
+func releaseFileAnnotations(annotations map[string]string) {
+	for _, keys := range heldFileAnnotations {
+		if value, ok := annotations[keys[0]]; ok {
+			for _, key := range keys[1:] {
+				annotations[key] = value
+			}
+			delete(annotations, keys[0])
+		}
+	}
+}
+
+// restoreAnnotations adds annotations to n.
+func restoreAnnotations(n *yaml.RNode, annotations map[string]string) error {
+	for _, key := range slices.Sorted(maps.Keys(annotations)) {
+		if err := n.PipeE(yaml.SetAnnotation(key, annotations[key])); err != nil {
+			return fmt.Errorf("while restoring annotation %s: %w", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,478 @@
+package extras
+
+// cSpell: words dotenv
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/cmd/sops/formats"
+	"sigs.k8s.io/kustomize/api/ifc"
+	kyaml_utils "sigs.k8s.io/kustomize/kyaml/utils"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	secretKind    = "Secret"
+	configMapKind = "ConfigMap"
+)
+
+// sopsFormats are the names of the formats of the encrypted files.
+var sopsFormats = []string{"yaml", "json", "dotenv", "ini", "binary"}
+
+// dataKeyRegexp matches the valid keys of Secrets and ConfigMaps.
+var dataKeyRegexp = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+// SopsFile is an encrypted file of the [SopsGeneratorPlugin].
+//
+// YAML and JSON files contain resources. The other files, dotenv, INI and
+// binary, as well as the YAML and JSON files with any of the Name, Namespace,
+// Kind, Type, Key or KeyPrefix fields, contain the data of a generated Secret
+// or ConfigMap. The files with the same kind, namespace and name contribute to
+// the same resource.
+//
+// A file can be specified by its path only.
+type SopsFile struct {
+	// Path is the path of the file.
+	Path string `json:"path" yaml:"path"`
+	// Format is the format of the file: yaml, json, dotenv, ini or binary. It
+	// is deduced from the file extension by default.
+	Format string `json:"format,omitempty" yaml:"format,omitempty"`
+	// Name is the name of the generated resource. It defaults to the name of
+	// the generator.
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Namespace is the namespace of the generated resource.
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	// Kind is the kind of the generated resource, Secret (default) or
+	// ConfigMap.
+	Kind string `json:"kind,omitempty" yaml:"kind,omitempty"`
+	// Type is the type of the generated Secret, Opaque by default.
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// Key is the key receiving the whole decrypted file. Without it, each entry
+	// of the file becomes a key, nested entries being named after their dotted
+	// path. The content of binary files is stored under the file name by
+	// default.
+	Key string `json:"key,omitempty" yaml:"key,omitempty"`
+	// KeyPrefix is prepended to the keys of the entries of the file.
+	KeyPrefix string `json:"keyPrefix,omitempty" yaml:"keyPrefix,omitempty"`
+}
+
+// UnmarshalJSON reads a file given either by its path or by its fields.
+func (f *SopsFile) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &f.Path); err == nil {
+		return nil
+	}
+	type plain SopsFile
+	if err := json.Unmarshal(b, (*plain)(f)); err != nil {
+		return fmt.Errorf("while reading sops file: %w", err)
+	}
+	return nil
+}
+
+// format returns the format of the file.
+func (f *SopsFile) format() (formats.Format, error) {
+	if f.Format == "" {
+		return formats.FormatForPath(f.Path), nil
+	}
+	if !slices.Contains(sopsFormats, f.Format) {
+		return formats.Binary, fmt.Errorf("invalid format %q of file %s, expected one of %v", f.Format, f.Path, sopsFormats)
+	}
+	return formats.FormatFromString(f.Format), nil
+}
+
+// containsResources returns true if the file contains resources instead of
+// the data of a generated resource.
+func (f *SopsFile) containsResources(format formats.Format) bool {
+	return (format == formats.Yaml || format == formats.Json) &&
+		f.Name == "" && f.Namespace == "" && f.Kind == "" && f.Type == "" && f.Key == "" && f.KeyPrefix == ""
+}
+
+// sopsDataResource is a Secret or ConfigMap generated from the data of
+// encrypted files.
+type sopsDataResource struct {
+	kind       string
+	name       string
+	namespace  string
+	secretType string
+	data       map[string][]byte
+}
+
+// sopsDataResources are the resources generated from the data of encrypted
+// files, in order of appearance.
+type sopsDataResources []*sopsDataResource
+
+// resource returns the generated resource with the given kind, name and
+// namespace, adding it if needed. secretType is the type of Secrets.
+func (r *sopsDataResources) resource(kind, name, namespace, secretType string) (*sopsDataResource, error) {
+	if kind == "" {
+		kind = secretKind
+	}
+	if kind != secretKind && kind != configMapKind {
+		return nil, fmt.Errorf("kind %s is neither %s nor %s", kind, secretKind, configMapKind)
+	}
+	if kind == configMapKind && secretType != "" {
+		return nil, fmt.Errorf("type %s only applies to Secrets", secretType)
+	}
+	index := slices.IndexFunc(*r, func(resource *sopsDataResource) bool {
+		return resource.kind == kind && resource.name == name && resource.namespace == namespace
+	})
+	if index < 0 {
+		*r = append(*r, &sopsDataResource{kind: kind, name: name, namespace: namespace, data: map[string][]byte{}})
+		index = len(*r) - 1
+	}
+	resource := (*r)[index]
+	if secretType != "" {
+		if resource.secretType != "" && resource.secretType != secretType {
+			return nil, fmt.Errorf("type %s conflicts with type %s of Secret %s", secretType, resource.secretType, name)
+		}
+		resource.secretType = secretType
+	}
+	return resource, nil
+}
+
+// nodes returns the generated resources.
+func (r sopsDataResources) nodes() ([]*yaml.RNode, error) {
+	nodes := make([]*yaml.RNode, 0, len(r))
+	for _, resource := range r {
+		node, err := resource.node()
+		if err != nil {
+			return nil, fmt.Errorf("while generating %s %s: %w", resource.kind, resource.name, err)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// set sets the value of key.
+func (r *sopsDataResource) set(key string, value []byte) error {
+	if !dataKeyRegexp.MatchString(key) {
+		return fmt.Errorf("invalid key %q for %s %s", key, r.kind, r.name)
+	}
+	if _, ok := r.data[key]; ok {
+		return fmt.Errorf("duplicate key %s in %s %s", key, r.kind, r.name)
+	}
+	r.data[key] = value
+	return nil
+}
+
+// node returns the generated resource.
+func (r *sopsDataResource) node() (*yaml.RNode, error) {
+	node := yaml.NewMapRNode(nil)
+	node.SetApiVersion("v1")
+	node.SetKind(r.kind)
+	if err := node.SetName(r.name); err != nil {
+		return nil, fmt.Errorf("while setting name: %w", err)
+	}
+	if r.namespace != "" {
+		if err := node.SetNamespace(r.namespace); err != nil {
+			return nil, fmt.Errorf("while setting namespace: %w", err)
+		}
+	}
+
+	data, binaryData := map[string]string{}, map[string]string{}
+	for key, value := range r.data {
+		switch {
+		case r.kind == secretKind:
+			data[key] = base64.StdEncoding.EncodeToString(value)
+		case utf8.Valid(value):
+			data[key] = string(value)
+		default:
+			binaryData[key] = base64.StdEncoding.EncodeToString(value)
+		}
+	}
+	if r.kind == secretKind {
+		secretType := r.secretType
+		if secretType == "" {
+			secretType = "Opaque"
+		}
+		if err := node.PipeE(yaml.SetField("type", yaml.NewStringRNode(secretType))); err != nil {
+			return nil, fmt.Errorf("while setting type: %w", err)
+		}
+	}
+	if len(data) > 0 {
+		node.SetDataMap(data)
+	}
+	if len(binaryData) > 0 {
+		node.SetBinaryDataMap(binaryData)
+	}
+	return node, nil
+}
+
+// addSopsFileData decrypts file and adds its data to the generated resources.
+func addSopsFileData(resources *sopsDataResources, file *SopsFile, format formats.Format, b []byte, defaultName string,
+	keys *sopsKeyRing,
+) error {
+	tree, store, err := decryptTree(b, format, file.Path, false, keys)
+	if err != nil {
+		return err
+	}
+	name := file.Name
+	if name == "" {
+		name = defaultName
+	}
+	resource, err := resources.resource(file.Kind, name, file.Namespace, file.Type)
+	if err != nil {
+		return err
+	}
+
+	key := file.Key
+	if key == "" && format == formats.Binary {
+		key = filepath.Base(file.Path)
+	}
+	if key != "" {
+		content, err := store.EmitPlainFile(tree.Branches)
+		if err != nil {
+			return fmt.Errorf("while reading decrypted file %s: %w", file.Path, err)
+		}
+		return resource.set(file.KeyPrefix+key, content)
+	}
+
+	for _, branch := range tree.Branches {
+		for _, item := range branch {
+			name, ok := item.Key.(string)
+			if !ok {
+				continue
+			}
+			if section, ok := item.Value.(sops.TreeBranch); ok && format == formats.Ini && name == "DEFAULT" {
+				// the entries outside of any INI section
+				if err = addSopsBranchData(resource, file.KeyPrefix, section); err != nil {
+					return fmt.Errorf("in file %s: %w", file.Path, err)
+				}
+				continue
+			}
+			if err = addSopsItemData(resource, file.KeyPrefix+name, item.Value); err != nil {
+				return fmt.Errorf("in file %s: %w", file.Path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// addSopsBranchData adds the entries of branch to resource, prefixing their
+// key with prefix.
+func addSopsBranchData(resource *sopsDataResource, prefix string, branch sops.TreeBranch) error {
+	for _, item := range branch {
+		if name, ok := item.Key.(string); ok {
+			if err := addSopsItemData(resource, prefix+name, item.Value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addSopsItemData adds the value of key to resource. Nested entries are
+// named after their dotted path.
+func addSopsItemData(resource *sopsDataResource, key string, value any) error {
+	switch v := value.(type) {
+	case sops.TreeBranch:
+		return addSopsBranchData(resource, key+".", v)
+	case []any:
+		return fmt.Errorf("entry %s is a list, use key to store the whole file", key)
+	case nil:
+		return resource.set(key, nil)
+	case string:
+		return resource.set(key, []byte(v))
+	case bool:
+		return resource.set(key, []byte(strconv.FormatBool(v)))
+	case int:
+		return resource.set(key, []byte(strconv.Itoa(v)))
+	case float64:
+		return resource.set(key, []byte(strconv.FormatFloat(v, 'f', -1, 64)))
+	default:
+		return resource.set(key, []byte(fmt.Sprint(v)))
+	}
+}
+
+// decryptSopsFiles decrypts files and returns the resources they contain
+// along with the resources generated from their data. defaultName is the
+// default name of the generated resources.
+func decryptSopsFiles(files []SopsFile, defaultName string, loader ifc.Loader,
+	keys *sopsKeyRing,
+) ([]*yaml.RNode, []*yaml.RNode, error) {
+	var nodes []*yaml.RNode
+	var resources sopsDataResources
+	for i := range files {
+		file := &files[i]
+		b, err := loader.Load(file.Path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("while reading manifest %q: %w", file.Path, err)
+		}
+		format, err := file.format()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !file.containsResources(format) {
+			if err = addSopsFileData(&resources, file, format, b, defaultName, keys); err != nil {
+				return nil, nil, fmt.Errorf("while decrypting file %q: %w", file.Path, err)
+			}
+			continue
+		}
+		fileNodes, err := decryptResources(b, format, file.Path, false, keys)
+		if err != nil {
+			return nil, nil, fmt.Errorf("while decrypting file %q: %w", file.Path, err)
+		}
+		for _, node := range fileNodes {
+			if node.GetKind() == "" {
+				return nil, nil, fmt.Errorf("file %q doesn't contain resources, "+
+					"set the name or the key of the resource to generate from its data", file.Path)
+			}
+		}
+		nodes = append(nodes, fileNodes...)
+	}
+	generated, err := resources.nodes()
+	if err != nil {
+		return nil, nil, err
+	}
+	return nodes, generated, nil
+}
+
+// SopsSecret is a Secret generated by the [SopsGeneratorPlugin] from a sub
+// tree of the decrypted resources.
+type SopsSecret struct {
+	// Name is the name of the Secret.
+	Name string `json:"name" yaml:"name"`
+	// Namespace is the namespace of the Secret.
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	// Type is the type of the Secret, like kubernetes.io/tls. It defaults to
+	// Opaque.
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// FieldPath is the path of the sub tree containing the values of the
+	// Secret, data by default. The first decrypted resource containing it is
+	// used. Keys containing dots are enclosed in brackets, like
+	// data.[tls.crt].
+	FieldPath string `json:"fieldPath,omitempty" yaml:"fieldPath,omitempty"`
+	// Keys maps the keys of the Secret to the paths of their values in the
+	// sub tree. Map and list values are encoded in JSON. Without it, each
+	// entry of the sub tree becomes a key, nested entries being named after
+	// their dotted path.
+	Keys map[string]string `json:"keys,omitempty" yaml:"keys,omitempty"`
+}
+
+// sopsSecretsField is the field of the inline configuration containing the
+// Secrets to generate.
+const sopsSecretsField = "secrets"
+
+// takeSopsSecrets removes the Secrets to generate from the decrypted inline
+// configuration n and returns them.
+func takeSopsSecrets(n *yaml.RNode) ([]SopsSecret, error) {
+	field := n.Field(sopsSecretsField)
+	if field == nil {
+		return nil, nil
+	}
+	var secrets []SopsSecret
+	if err := field.Value.YNode().Decode(&secrets); err != nil {
+		return nil, fmt.Errorf("while reading secrets: %w", err)
+	}
+	if err := n.PipeE(yaml.Clear(sopsSecretsField)); err != nil {
+		return nil, fmt.Errorf("while removing secrets: %w", err)
+	}
+	return secrets, nil
+}
+
+// generateSopsSecrets generates secrets from the decrypted documents. It
+// returns the generated Secrets along with the documents used as their
+// source.
+func generateSopsSecrets(secrets []SopsSecret, documents []*yaml.RNode) ([]*yaml.RNode, map[*yaml.RNode]bool, error) {
+	var resources sopsDataResources
+	sources := map[*yaml.RNode]bool{}
+	for i := range secrets {
+		secret := &secrets[i]
+		if secret.Name == "" {
+			return nil, nil, fmt.Errorf("secret %d has no name", i)
+		}
+		fieldPath := secret.FieldPath
+		if fieldPath == "" {
+			fieldPath = yaml.DataField
+		}
+		var document, tree *yaml.RNode
+		for _, document = range documents {
+			var err error
+			if tree, err = document.Pipe(yaml.Lookup(kyaml_utils.SmarterPathSplitter(fieldPath, ".")...)); err != nil {
+				return nil, nil, fmt.Errorf("while looking up %s for Secret %s: %w", fieldPath, secret.Name, err)
+			}
+			if tree != nil {
+				break
+			}
+		}
+		if tree == nil {
+			return nil, nil, fmt.Errorf("field %s of Secret %s not found in the decrypted resources", fieldPath, secret.Name)
+		}
+		sources[document] = true
+
+		resource, err := resources.resource(secretKind, secret.Name, secret.Namespace, secret.Type)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = addSopsSecretData(resource, secret, fieldPath, tree); err != nil {
+			return nil, nil, fmt.Errorf("while generating Secret %s: %w", secret.Name, err)
+		}
+	}
+	nodes, err := resources.nodes()
+	if err != nil {
+		return nil, nil, err
+	}
+	return nodes, sources, nil
+}
+
+// addSopsSecretData adds the values of tree, the sub tree at fieldPath, to the
+// generated Secret resource.
+func addSopsSecretData(resource *sopsDataResource, secret *SopsSecret, fieldPath string, tree *yaml.RNode) error {
+	if len(secret.Keys) == 0 {
+		if tree.YNode().Kind != yaml.MappingNode {
+			return fmt.Errorf("field %s is not a map, use keys to select its values", fieldPath)
+		}
+		return addSopsNodeData(resource, "", tree)
+	}
+	for _, key := range yaml.SortedMapKeys(secret.Keys) {
+		path := secret.Keys[key]
+		value, err := tree.Pipe(yaml.Lookup(kyaml_utils.SmarterPathSplitter(path, ".")...))
+		if err != nil {
+			return fmt.Errorf("while looking up %s: %w", path, err)
+		}
+		if value == nil {
+			return fmt.Errorf("field %s of key %s not found", path, key)
+		}
+		var b []byte
+		switch {
+		case value.YNode().Kind == yaml.ScalarNode && value.YNode().Tag == yaml.NodeTagNull:
+		case value.YNode().Kind == yaml.ScalarNode:
+			b = []byte(value.YNode().Value)
+		default:
+			if b, err = value.MarshalJSON(); err != nil {
+				return fmt.Errorf("while encoding %s: %w", path, err)
+			}
+		}
+		if err = resource.set(key, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addSopsNodeData adds the entries of the map node to resource, prefixing
+// their key with prefix. Nested entries are named after their dotted path.
+func addSopsNodeData(resource *sopsDataResource, prefix string, node *yaml.RNode) error {
+	return node.VisitFields(func(field *yaml.MapNode) error {
+		key := prefix + field.Key.YNode().Value
+		value := field.Value.YNode()
+		switch {
+		case value.Kind == yaml.MappingNode:
+			return addSopsNodeData(resource, key+".", field.Value)
+		case value.Kind == yaml.SequenceNode:
+			return fmt.Errorf("entry %s is a list, use keys to store it", key)
+		case value.Tag == yaml.NodeTagNull:
+			return resource.set(key, nil)
+		default:
+			return resource.set(key, []byte(value.Value))
+		}
+	})
+}
@@ -0,0 +1,241 @@
+package extras
+
+// cSpell: words keyservice gnupg grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/keyservice"
+	"github.com/getsops/sops/v3/pgp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// localKeyServiceName is the name of the in process key service in errors.
+const localKeyServiceName = "local key service"
+
+// SopsKeySources configures the sources of the keys decrypting the data keys
+// of the [SopsGeneratorPlugin], [SopsDecryptTransformerPlugin] and
+// [SopsEncryptTransformerPlugin]. Relative paths are relative to the root of
+// the plugin loader, which is the current directory, i.e. the root of the
+// project.
+//
+// Without any source, the keys are looked up in the environment, like with
+// the sops command.
+type SopsKeySources struct {
+	// AgeKeyFile is the path of a file containing age identities. When set,
+	// the age identities of the environment are not used.
+	AgeKeyFile string `json:"ageKeyFile,omitempty" yaml:"ageKeyFile,omitempty"`
+	// GnuPGHome is the GnuPG home directory containing the PGP keys.
+	GnuPGHome string `json:"gnupgHome,omitempty" yaml:"gnupgHome,omitempty"`
+	// KeyServices are the addresses of remote sops key services, like
+	// tcp://localhost:5000 or unix:///run/sops.sock. They are tried after the
+	// local keys.
+	KeyServices []string `json:"keyServices,omitempty" yaml:"keyServices,omitempty"`
+}
+
+// sopsKeyService is a key service able to decrypt the data keys.
+type sopsKeyService struct {
+	name   string
+	client keyservice.KeyServiceClient
+}
+
+// sopsKeyRing are the key services decrypting the data keys, in order of
+// preference.
+type sopsKeyRing struct {
+	services    []sopsKeyService
+	connections []*grpc.ClientConn
+}
+
+// defaultSopsKeyRing returns the key ring using the keys of the environment.
+func defaultSopsKeyRing() *sopsKeyRing {
+	return &sopsKeyRing{services: []sopsKeyService{{localKeyServiceName, keyservice.NewLocalClient()}}}
+}
+
+// keyRing returns the key ring made from the sources. root is the root of
+// the plugin loader, against which the relative paths are resolved.
+func (s *SopsKeySources) keyRing(root string) (*sopsKeyRing, error) {
+	if s == nil {
+		return defaultSopsKeyRing(), nil
+	}
+	local := sopsLocalKeyServer{}
+	if s.AgeKeyFile != "" {
+		b, err := os.ReadFile(absolutePath(root, s.AgeKeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("while reading age key file: %w", err)
+		}
+		if err = local.ageIdentities.Import(string(b)); err != nil {
+			return nil, fmt.Errorf("while reading age key file %s: %w", s.AgeKeyFile, err)
+		}
+	}
+	if s.GnuPGHome != "" {
+		local.gnuPGHome = pgp.GnuPGHome(absolutePath(root, s.GnuPGHome))
+		if err := local.gnuPGHome.Validate(); err != nil {
+			return nil, fmt.Errorf("while checking GnuPG home: %w", err)
+		}
+	}
+
+	ring := &sopsKeyRing{services: []sopsKeyService{{localKeyServiceName, keyservice.NewCustomLocalClient(local)}}}
+	for _, address := range s.KeyServices {
+		conn, err := dialKeyService(address)
+		if err != nil {
+			ring.close()
+			return nil, err
+		}
+		ring.connections = append(ring.connections, conn)
+		ring.services = append(ring.services, sopsKeyService{address, keyservice.NewKeyServiceClient(conn)})
+	}
+	return ring, nil
+}
+
+// absolutePath returns path made absolute relatively to root.
+func absolutePath(root, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(root, path)
+}
+
+// dialKeyService returns a connection to the key service listening at
+// address. The connection is established on first use.
+func dialKeyService(address string) (*grpc.ClientConn, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing key service address %s: %w", address, err)
+	}
+	target := u.Host
+	options := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	switch u.Scheme {
+	case "unix":
+		target = address
+	case "tcp", "tcp4", "tcp6":
+		options = append(options, grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, u.Scheme, addr)
+		}))
+	default:
+		return nil, fmt.Errorf("invalid key service address %s, expected tcp://host:port or unix:///path", address)
+	}
+	conn, err := grpc.NewClient(target, options...)
+	if err != nil {
+		return nil, fmt.Errorf("while connecting to key service %s: %w", address, err)
+	}
+	return conn, nil
+}
+
+// clients returns the clients of the key services.
+func (r *sopsKeyRing) clients() []keyservice.KeyServiceClient {
+	clients := make([]keyservice.KeyServiceClient, len(r.services))
+	for i, service := range r.services {
+		clients[i] = service.client
+	}
+	return clients
+}
+
+// close closes the connections to the remote key services.
+func (r *sopsKeyRing) close() {
+	for _, conn := range r.connections {
+		_ = conn.Close()
+	}
+	r.connections = nil
+}
+
+// dataKeyError explains why the data key of metadata cannot be decrypted. It
+// lists the key groups that cannot be decrypted along with the error of each
+// of their keys with each key service.
+func (r *sopsKeyRing) dataKeyError(metadata *sops.Metadata) error {
+	required := 1
+	if len(metadata.KeyGroups) > 1 {
+		required = metadata.ShamirThreshold
+	}
+	var errs []error
+	failed := 0
+	for i, group := range metadata.KeyGroups {
+		if groupErrs := r.keyGroupErrors(group); len(groupErrs) > 0 {
+			failed++
+			for _, err := range groupErrs {
+				errs = append(errs, fmt.Errorf("key group %d, %w", i+1, err))
+			}
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("cannot decrypt the data key, %d key group(s) required, %d out of %d decrypted:\n%w",
+		required, len(metadata.KeyGroups)-failed, len(metadata.KeyGroups), errors.Join(errs...))
+}
+
+// keyGroupErrors returns the errors of the keys of group with each key
+// service, or nil if any of them decrypts its part of the data key.
+func (r *sopsKeyRing) keyGroupErrors(group sops.KeyGroup) []error {
+	if len(group) == 0 {
+		return []error{errors.New("no key")}
+	}
+	var errs []error
+	for _, key := range group {
+		request := &keyservice.DecryptRequest{Ciphertext: key.EncryptedDataKey()}
+		serviceKey := keyservice.KeyFromMasterKey(key)
+		request.Key = &serviceKey
+		for _, service := range r.services {
+			if _, err := service.client.Decrypt(context.Background(), request); err != nil {
+				errs = append(errs, fmt.Errorf("%s key %s with %s: %w", key.TypeToIdentifier(), key.ToString(), service.name, err))
+				continue
+			}
+			return nil
+		}
+	}
+	return errs
+}
+
+// sopsLocalKeyServer is a local key service using the configured age
+// identities and GnuPG home instead of the ones of the environment.
+type sopsLocalKeyServer struct {
+	ageIdentities age.ParsedIdentities
+	gnuPGHome     pgp.GnuPGHome
+}
+
+// Decrypt decrypts the data key of the request.
+func (s sopsLocalKeyServer) Decrypt(ctx context.Context,
+	req *keyservice.DecryptRequest,
+) (*keyservice.DecryptResponse, error) {
+	switch k := req.GetKey().GetKeyType().(type) {
+	case *keyservice.Key_AgeKey:
+		if s.ageIdentities == nil {
+			break
+		}
+		key := age.MasterKey{Recipient: k.AgeKey.GetRecipient(), EncryptedKey: string(req.GetCiphertext())}
+		s.ageIdentities.ApplyToMasterKey(&key)
+		plaintext, err := key.Decrypt()
+		if err != nil {
+			return nil, err //nolint:wrapcheck // explained by the caller
+		}
+		return &keyservice.DecryptResponse{Plaintext: plaintext}, nil
+	case *keyservice.Key_PgpKey:
+		if s.gnuPGHome == "" {
+			break
+		}
+		key := pgp.NewMasterKeyFromFingerprint(k.PgpKey.GetFingerprint())
+		key.EncryptedKey = string(req.GetCiphertext())
+		s.gnuPGHome.ApplyToMasterKey(key)
+		plaintext, err := key.Decrypt()
+		if err != nil {
+			return nil, err //nolint:wrapcheck // explained by the caller
+		}
+		return &keyservice.DecryptResponse{Plaintext: plaintext}, nil
+	}
+	return keyservice.Server{}.Decrypt(ctx, req) //nolint:wrapcheck // explained by the caller
+}
+
+// Encrypt encrypts the data key of the request with the environment keys.
+func (s sopsLocalKeyServer) Encrypt(ctx context.Context,
+	req *keyservice.EncryptRequest,
+) (*keyservice.EncryptResponse, error) {
+	return keyservice.Server{}.Encrypt(ctx, req) //nolint:wrapcheck // explained by the caller
+}
@@ -0,0 +1,301 @@
+package extras
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/kyaml/resid"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// VirtualSourceAPIVersion is the API version of the synthetic resources
+	// created by the virtual replacement sources.
+	VirtualSourceAPIVersion = "config.karmafun.dev/v1alpha1"
+	// EnvironmentSourceKind is the kind of the resource created by an env://
+	// source.
+	EnvironmentSourceKind = "Environment"
+	// GitSourceKind is the kind of the resource created by a git:// source.
+	GitSourceKind = "GitRepository"
+	// FileSourceKind is the kind of the resource created by a file:// source.
+	FileSourceKind = "File"
+
+	envSourceScheme  = "env://"
+	gitSourceScheme  = "git://"
+	fileSourceScheme = "file://"
+)
+
+// virtualSourceFactory creates the synthetic resource of a virtual source
+// given the part of the source after the scheme.
+type virtualSourceFactory func(h *resmap.PluginHelpers, path string) (*yaml.RNode, error)
+
+// virtualSourceFactories register the virtual source factories for each
+// scheme.
+var virtualSourceFactories = map[string]virtualSourceFactory{
+	envSourceScheme:  newEnvironmentSource,
+	gitSourceScheme:  newGitSource,
+	fileSourceScheme: newFileSource,
+}
+
+// newVirtualSourceNode returns a synthetic resource of the passed kind and
+// name containing data.
+func newVirtualSourceNode(kind, name string, data map[string]string) (*yaml.RNode, error) {
+	node := yaml.NewRNode(&yaml.Node{Kind: yaml.MappingNode})
+	node.SetApiVersion(VirtualSourceAPIVersion)
+	node.SetKind(kind)
+	if err := node.SetName(name); err != nil {
+		return nil, fmt.Errorf("while setting name: %w", err)
+	}
+	node.SetDataMap(data)
+	return node, nil
+}
+
+// loadVirtualSource returns the synthetic resource of the virtual source
+// path. It returns nil if path is not a virtual source.
+func loadVirtualSource(h *resmap.PluginHelpers, path string) (*yaml.RNode, error) {
+	for scheme, factory := range virtualSourceFactories {
+		if rest, ok := strings.CutPrefix(path, scheme); ok {
+			return factory(h, rest)
+		}
+	}
+	return nil, nil //nolint:nilnil // not a virtual source
+}
+
+// newEnvironmentSource returns an Environment resource containing the
+// environment variables allowed by allowList.
+//
+// allowList is a comma separated list of variable names. A name ending with
+// "*" allows all the variables starting with the rest of the name.
+func newEnvironmentSource(_ *resmap.PluginHelpers, allowList string) (*yaml.RNode, error) {
+	if allowList == "" {
+		return nil, fmt.Errorf("env source needs a list of allowed variables, like env://CI_COMMIT_SHA,CI_*")
+	}
+	allowed := strings.Split(allowList, ",")
+	data := map[string]string{}
+	for _, variable := range os.Environ() {
+		name, value, _ := strings.Cut(variable, "=")
+		for _, a := range allowed {
+			prefix, isPrefix := strings.CutSuffix(a, "*")
+			if name == a || (isPrefix && strings.HasPrefix(name, prefix)) {
+				data[name] = value
+				break
+			}
+		}
+	}
+	return newVirtualSourceNode(EnvironmentSourceKind, "env", data)
+}
+
+// newGitSource returns a GitRepository resource describing the git repository
+// enclosing the kustomization. It contains:
+//
+//   - branch: the current branch, empty if HEAD is detached.
+//   - sha: the hash of the current commit.
+//   - remote: the URL of the remote named remoteName (origin by default).
+//   - tag: the tag pointing to the current commit, if any.
+func newGitSource(h *resmap.PluginHelpers, remoteName string) (*yaml.RNode, error) {
+	repo, err := openGitRepository(h.Loader().Root())
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]string{
+		"sha":    repo.head.Hash().String(),
+		"branch": repo.branch(),
+		"remote": "",
+		"tag":    "",
+	}
+	remote, err := repo.remoteURL(remoteName)
+	switch {
+	case err == nil:
+		data["remote"] = remote
+	case remoteName != "":
+		return nil, err
+	}
+
+	data["tag"], err = repo.tag()
+	if err != nil {
+		return nil, err
+	}
+	return newVirtualSourceNode(GitSourceKind, "git", data)
+}
+
+// newFileSource returns a File resource named after the base name of path
+// with the raw content of the file in data.content.
+func newFileSource(h *resmap.PluginHelpers, path string) (*yaml.RNode, error) {
+	if path == "" {
+		return nil, fmt.Errorf("file source needs a path, like file://ca.crt")
+	}
+	content, err := h.Loader().Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("while loading file source %s: %w", path, err)
+	}
+	return newVirtualSourceNode(FileSourceKind, filepath.Base(path), map[string]string{
+		"path":    path,
+		"content": string(content),
+	})
+}
+
+// SourcePaths is the list of paths in which the replacement sources are
+// selected. It can be specified as a single path or as a list of paths.
+type SourcePaths []string
+
+// UnmarshalYAML accepts a single path as well as a list of paths.
+func (s *SourcePaths) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*s = SourcePaths{value.Value}
+		return nil
+	}
+	var paths []string
+	if err := value.Decode(&paths); err != nil {
+		return fmt.Errorf("while decoding source paths: %w", err)
+	}
+	*s = paths
+	return nil
+}
+
+// isVirtualSource returns true if path designates a virtual source.
+func isVirtualSource(path string) bool {
+	for scheme := range virtualSourceFactories {
+		if strings.HasPrefix(path, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandSourcePaths returns the paths with the glob patterns replaced by the
+// files they match, relative to root.
+func expandSourcePaths(root string, paths SourcePaths) ([]string, error) {
+	var result []string
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if isVirtualSource(path) || !strings.ContainsAny(path, "*?[") {
+			result = append(result, path)
+			continue
+		}
+		pattern := path
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(root, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("while expanding source %s: %w", path, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("source %s matches no file", path)
+		}
+		for _, match := range matches {
+			if !filepath.IsAbs(path) {
+				if relative, relErr := filepath.Rel(root, match); relErr == nil {
+					match = relative
+				}
+			}
+			result = append(result, match)
+		}
+	}
+	return result, nil
+}
+
+// sourceLayers contains the resources in which the replacement sources are
+// selected.
+type sourceLayers struct {
+	// nodes are the source resources. Resources with the same id in several
+	// layers are merged.
+	nodes []*yaml.RNode
+	// origins gives the layer each node comes from. It is nil when the
+	// resources come from a single layer.
+	origins map[*yaml.Node]string
+}
+
+// loadSourceLayers loads the resources of each path and deep merges the
+// resources having the same id. Values of the later paths take precedence.
+func loadSourceLayers(h *resmap.PluginHelpers, paths SourcePaths) (*sourceLayers, error) {
+	expanded, err := expandSourcePaths(h.Loader().Root(), paths)
+	if err != nil {
+		return nil, err
+	}
+	result := &sourceLayers{}
+	if len(expanded) > 1 {
+		result.origins = map[*yaml.Node]string{}
+	}
+	byId := map[string]*yaml.RNode{}
+	for _, path := range expanded {
+		source, loadErr := loadSource(h, path)
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		for _, node := range source.ToRNodeSlice() {
+			if result.origins != nil {
+				recordOrigin(result.origins, node.YNode(), path)
+			}
+			id := resid.FromRNode(node).String()
+			if existing, ok := byId[id]; ok {
+				mergeNode(existing.YNode(), node.YNode())
+				continue
+			}
+			byId[id] = node
+			result.nodes = append(result.nodes, node)
+		}
+	}
+	return result, nil
+}
+
+// recordOrigin records path as the origin of node and of all its descendants.
+func recordOrigin(origins map[*yaml.Node]string, node *yaml.Node, path string) {
+	origins[node] = path
+	for _, child := range node.Content {
+		recordOrigin(origins, child, path)
+	}
+}
+
+// mergeNode deep merges the mapping src into the mapping dst. Mappings present
+// in both are merged key by key while other values of src replace the ones of
+// dst.
+func mergeNode(dst, src *yaml.Node) {
+	if dst.Kind != yaml.MappingNode || src.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		index := -1
+		for j := 0; j+1 < len(dst.Content); j += 2 {
+			if dst.Content[j].Value == key.Value {
+				index = j
+				break
+			}
+		}
+		switch {
+		case index < 0:
+			dst.Content = append(dst.Content, key, value)
+		case dst.Content[index+1].Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			mergeNode(dst.Content[index+1], value)
+		default:
+			dst.Content[index+1] = value
+		}
+	}
+}
+
+// originsOf returns the distinct layers the node and its descendants come
+// from, in order of appearance.
+func (l *sourceLayers) originsOf(node *yaml.Node) []string {
+	if l == nil || l.origins == nil {
+		return nil
+	}
+	var result []string
+	var walk func(n *yaml.Node)
+	walk = func(n *yaml.Node) {
+		if origin, ok := l.origins[n]; ok && !slices.Contains(result, origin) {
+			result = append(result, origin)
+		}
+		for _, child := range n.Content {
+			walk(child)
+		}
+	}
+	walk(node)
+	return result
+}
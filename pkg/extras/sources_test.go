@@ -0,0 +1,434 @@
+package extras_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+//nolint:paralleltest // t.Setenv cannot be used in parallel tests
+func TestReplacementVirtualSources(t *testing.T) {
+	t.Setenv("KARMAFUN_TEST_REVISION", "deploy/ci")
+	t.Setenv("KARMAFUN_TEST_SECRET", "hidden")
+
+	caPath := filepath.Join(t.TempDir(), "ca.crt")
+	ca := "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"
+	require.NoError(t, os.WriteFile(caPath, []byte(ca), 0o600))
+
+	repo, err := git.PlainOpenWithOptions(".", &git.PlainOpenOptions{DetectDotGit: true})
+	require.NoError(t, err)
+	head, err := repo.Head()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		config  string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "transformer level env source",
+			config: `
+source: env://KARMAFUN_TEST_REV*
+replacements:
+  - source:
+      kind: Environment
+      fieldPath: data.KARMAFUN_TEST_REVISION
+    targets:
+      - select:
+          kind: Application
+        fieldPaths:
+          - spec.source.targetRevision
+`,
+			want: "    targetRevision: deploy/ci\n",
+		},
+		{
+			name: "env variable not allowed",
+			config: `
+replacements:
+  - from: env://KARMAFUN_TEST_REVISION
+    source:
+      kind: Environment
+      fieldPath: data.KARMAFUN_TEST_SECRET
+    targets:
+      - select:
+          kind: Application
+        fieldPaths:
+          - spec.source.targetRevision
+`,
+			wantErr: "fieldPath `data.KARMAFUN_TEST_SECRET` is missing",
+		},
+		{
+			name: "env source without allow list",
+			config: `
+replacements:
+  - from: env://
+    source:
+      kind: Environment
+      fieldPath: data.KARMAFUN_TEST_SECRET
+    targets:
+      - select:
+          kind: Application
+        fieldPaths:
+          - spec.source.targetRevision
+`,
+			wantErr: "needs a list of allowed variables",
+		},
+		{
+			name: "git source",
+			config: `
+replacements:
+  - from: git://
+    source:
+      kind: GitRepository
+      fieldPath: data.sha
+    targets:
+      - select:
+          kind: Application
+        fieldPaths:
+          - spec.source.targetRevision
+`,
+			want: fmt.Sprintf("    targetRevision: %s\n", head.Hash()),
+		},
+		{
+			name: "file source",
+			config: fmt.Sprintf(`
+replacements:
+  - from: file://%s
+    source:
+      kind: File
+      name: ca.crt
+      fieldPath: data.content
+    targets:
+      - select:
+          kind: ConfigMap
+        fieldPaths:
+          - data.ca\.crt
+        options:
+          create: true
+`, caPath),
+			want: "  ca.crt: |\n    -----BEGIN CERTIFICATE-----\n    MIIB\n",
+		},
+		{
+			name: "replacement source overrides transformer source",
+			config: `
+source: env://KARMAFUN_TEST_REVISION
+replacements:
+  - from: env://KARMAFUN_TEST_SECRET
+    source:
+      kind: Environment
+      fieldPath: data.KARMAFUN_TEST_SECRET
+    targets:
+      - select:
+          kind: Application
+        fieldPaths:
+          - spec.source.targetRevision
+`,
+			want: "    targetRevision: hidden\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			got, _, err := runReplacementTransformer(t, tt.config, replacementResources)
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			req.Contains(got, tt.want)
+		})
+	}
+}
+
+func TestReplacementLayeredSources(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	layers := map[string]string{
+		"00-common.yaml": `apiVersion: config.karmafun.dev/v1alpha1
+kind: PlatformValues
+metadata:
+  name: values
+data:
+  repoURL: https://github.com/karmafun/karmafun.git
+  targetRevision: main
+  helm:
+    release: app
+    replicas: 1
+`,
+		"10-prod.yaml": `apiVersion: config.karmafun.dev/v1alpha1
+kind: PlatformValues
+metadata:
+  name: values
+data:
+  targetRevision: deploy/prod
+  helm:
+    replicas: 3
+`,
+	}
+	layers["20-secret.yaml"] = `apiVersion: v1
+kind: Secret
+metadata:
+  name: credentials
+data:
+  password: c2VjcmV0
+`
+	for name, content := range layers {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	common := filepath.Join(dir, "00-common.yaml")
+	prod := filepath.Join(dir, "10-prod.yaml")
+	secret := filepath.Join(dir, "20-secret.yaml")
+
+	tests := []struct {
+		name      string
+		source    string
+		kind      string
+		fieldPath string
+		target    string
+		want      string
+		origins   string
+		value     string
+		wantErr   string
+	}{
+		{
+			name:      "later layer wins",
+			source:    fmt.Sprintf("[%s, %s]", common, prod),
+			fieldPath: "data.targetRevision",
+			target:    "spec.source.targetRevision",
+			want:      "    targetRevision: deploy/prod\n",
+			origins:   prod,
+			value:     "deploy/prod",
+		},
+		{
+			name:      "secret value is redacted",
+			source:    fmt.Sprintf("[%s, %s]", common, secret),
+			kind:      "Secret",
+			fieldPath: "data.password",
+			target:    "spec.source.targetRevision",
+			want:      "    targetRevision: secret\n",
+			origins:   secret,
+			value:     "<redacted>",
+		},
+		{
+			name:      "value from first layer",
+			source:    fmt.Sprintf("[%s, %s]", common, prod),
+			fieldPath: "data.repoURL",
+			target:    "spec.source.repoURL",
+			want:      "    repoURL: https://github.com/karmafun/karmafun.git\n",
+			origins:   common,
+		},
+		{
+			name:      "deep merged mapping",
+			source:    fmt.Sprintf("[%s, %s]", common, prod),
+			fieldPath: "data.helm",
+			target:    "spec.source.helm.values.!!yaml.helm",
+			want:      "        helm:\n          release: app\n          replicas: 3\n",
+			origins:   common + ", " + prod,
+		},
+		{
+			name:      "glob pattern",
+			source:    filepath.Join(dir, "*.yaml"),
+			fieldPath: "data.targetRevision",
+			target:    "spec.source.targetRevision",
+			want:      "    targetRevision: deploy/prod\n",
+			origins:   prod,
+		},
+		{
+			name:      "reversed layers",
+			source:    fmt.Sprintf("[%s, %s]", prod, common),
+			fieldPath: "data.targetRevision",
+			target:    "spec.source.targetRevision",
+			want:      "    targetRevision: main\n",
+			origins:   common,
+		},
+		{
+			name:      "glob matching nothing",
+			source:    filepath.Join(dir, "*.yml"),
+			fieldPath: "data.targetRevision",
+			target:    "spec.source.targetRevision",
+			wantErr:   "matches no file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := require.New(t)
+			kind := tt.kind
+			if kind == "" {
+				kind = "PlatformValues"
+			}
+			config := fmt.Sprintf(`
+source: %s
+replacements:
+  - source:
+      kind: %s
+      fieldPath: %s
+    targets:
+      - select:
+          kind: Application
+        fieldPaths:
+          - %s
+`, tt.source, kind, tt.fieldPath, tt.target)
+			got, results, err := runReplacementTransformer(t, config, replacementResources)
+			results = withoutReport(results)
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			req.Contains(got, tt.want)
+			req.Len(results, 1)
+			req.Equal(framework.Info, results[0].Severity)
+			req.Contains(results[0].Message, "comes from "+tt.origins)
+			if tt.value != "" {
+				req.Equal(tt.value, results[0].Field.CurrentValue)
+			}
+		})
+	}
+}
+
+const correlatedResources = `apiVersion: config.karmafun.dev/v1alpha1
+kind: PlatformValues
+metadata:
+  name: app-a
+  labels:
+    team: alpha
+data:
+  revision: rev-a
+---
+apiVersion: config.karmafun.dev/v1alpha1
+kind: PlatformValues
+metadata:
+  name: app-b
+  labels:
+    team: beta
+data:
+  revision: rev-b
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: revisions
+data:
+  app-a: cm-a
+  app-b: cm-b
+  app-c: cm-c
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: app-a
+  labels:
+    team: alpha
+spec:
+  source:
+    targetRevision: main
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: app-b
+  labels:
+    team: beta
+spec:
+  source:
+    targetRevision: main
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: app-c
+spec:
+  source:
+    targetRevision: main
+`
+
+func TestReplacementCorrelatedSource(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		source    string
+		strict    bool
+		revisions []string
+		unsourced bool
+		wantErr   string
+	}{
+		{
+			name:      "name",
+			source:    "{kind: PlatformValues, name: '${target.metadata.name}', fieldPath: data.revision}",
+			revisions: []string{"rev-a", "rev-b", "main"},
+			unsourced: true,
+		},
+		{
+			name:      "label",
+			source:    "{kind: PlatformValues, labelSelector: 'team=${target.metadata.labels.team}', fieldPath: data.revision}",
+			revisions: []string{"rev-a", "rev-b", "main"},
+			unsourced: true,
+		},
+		{
+			name:      "field path",
+			source:    "{kind: ConfigMap, fieldPath: 'data.${target.metadata.name}'}",
+			revisions: []string{"cm-a", "cm-b", "cm-c"},
+		},
+		{
+			name:    "strict",
+			source:  "{kind: PlatformValues, name: '${target.metadata.name}', fieldPath: data.revision}",
+			strict:  true,
+			wantErr: "nothing selected",
+		},
+		{
+			name:    "strict missing target field",
+			source:  "{kind: PlatformValues, labelSelector: 'team=${target.metadata.labels.team}', fieldPath: data.revision}",
+			strict:  true,
+			wantErr: "target field metadata.labels.team",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := require.New(t)
+			config := fmt.Sprintf(`
+strict: %t
+replacements:
+  - source: %s
+    targets:
+      - select:
+          kind: Application
+        fieldPaths:
+          - spec.source.targetRevision
+`, tt.strict, tt.source)
+			got, results, err := runReplacementTransformer(t, config, correlatedResources)
+			results = withoutReport(results)
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			nodes, err := kio.FromBytes([]byte(got))
+			req.NoError(err)
+			var revisions []string
+			for _, n := range nodes {
+				if n.GetKind() == "Application" {
+					revision, lookupErr := n.Pipe(yaml.Lookup("spec", "source", "targetRevision"))
+					req.NoError(lookupErr)
+					revisions = append(revisions, yaml.GetValue(revision))
+				}
+			}
+			req.Equal(tt.revisions, revisions)
+			if !tt.unsourced {
+				req.Empty(results)
+				return
+			}
+			req.Len(results, 1)
+			req.Contains(results[0].Message, "skipped 1 resource(s) without a matching source")
+		})
+	}
+}
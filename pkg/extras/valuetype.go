@@ -0,0 +1,147 @@
+package extras
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// ValueType enumerates the types a replacement value can be converted to.
+//
+//go:generate go run golang.org/x/tools/cmd/stringer -type=ValueType
+type ValueType int
+
+const (
+	UnknownValueType ValueType = iota
+	StringValueType
+	BoolValueType
+	IntValueType
+	FloatValueType
+	AutoValueType
+)
+
+var stringToValueTypeMap map[string]ValueType
+
+func init() { //nolint:gochecknoinits // mimics the kustomize pattern used for plugins.
+	stringToValueTypeMap = makeStringToValueTypeMap()
+}
+
+// makeStringToValueTypeMap makes a map to get the appropriate [ValueType]
+// given its name.
+func makeStringToValueTypeMap() map[string]ValueType {
+	result := make(map[string]ValueType, len(ValueConverterFactories))
+	for k := range ValueConverterFactories {
+		result[strings.Replace(strings.ToLower(k.String()), "valuetype", "", 1)] = k
+	}
+	return result
+}
+
+// getValueType returns the appropriate [ValueType] for the passed type name.
+func getValueType(n string) ValueType {
+	result, ok := stringToValueTypeMap[strings.ToLower(n)]
+	if ok {
+		return result
+	}
+	return UnknownValueType
+}
+
+// ValueConverter converts the scalar value to a specific type. It returns the
+// canonical representation of the value and its YAML tag.
+type ValueConverter func(value string) (string, string, error)
+
+// ConvertToString keeps the value as is but makes it a string.
+func ConvertToString(value string) (string, string, error) {
+	return value, yaml.NodeTagString, nil
+}
+
+// ConvertToBool converts value to a boolean.
+func ConvertToBool(value string) (string, string, error) {
+	b, err := strconv.ParseBool(strings.ToLower(value))
+	if err != nil {
+		return "", "", fmt.Errorf("value %q is not a boolean", value)
+	}
+	return strconv.FormatBool(b), yaml.NodeTagBool, nil
+}
+
+// ConvertToInt converts value to a decimal integer.
+func ConvertToInt(value string) (string, string, error) {
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return "", "", fmt.Errorf("value %q is not an integer", value)
+	}
+	return strconv.FormatInt(i, 10), yaml.NodeTagInt, nil
+}
+
+// ConvertToFloat converts value to a floating point number. The value is kept
+// as written, like 1.20, in order not to alter it.
+func ConvertToFloat(value string) (string, string, error) {
+	if _, err := strconv.ParseFloat(value, 64); err != nil {
+		return "", "", fmt.Errorf("value %q is not a number", value)
+	}
+	return value, yaml.NodeTagFloat, nil
+}
+
+// autoFloatRegexp matches the floating point numbers of the YAML core schema.
+var autoFloatRegexp = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+
+// leadingZeroRegexp matches the numbers with a leading zero, like zip codes,
+// that are kept as strings by [ConvertAuto].
+var leadingZeroRegexp = regexp.MustCompile(`^[-+]?0[0-9]`)
+
+// ConvertAuto infers the type of value from its representation, like a
+// plain YAML scalar would. Numbers with a leading zero stay strings.
+func ConvertAuto(value string) (string, string, error) {
+	switch {
+	case strings.EqualFold(value, "true") || strings.EqualFold(value, "false"):
+		return ConvertToBool(value)
+	case leadingZeroRegexp.MatchString(value):
+		return ConvertToString(value)
+	case autoFloatRegexp.MatchString(value):
+		if converted, tag, err := ConvertToInt(value); err == nil {
+			return converted, tag, nil
+		}
+		return ConvertToFloat(value)
+	}
+	return ConvertToString(value)
+}
+
+// ValueConverterFactories register the [ValueConverter] functions for each
+// [ValueType].
+var ValueConverterFactories = map[ValueType]ValueConverter{
+	StringValueType: ConvertToString,
+	BoolValueType:   ConvertToBool,
+	IntValueType:    ConvertToInt,
+	FloatValueType:  ConvertToFloat,
+	AutoValueType:   ConvertAuto,
+}
+
+// GetTypedValue returns a copy of the scalar node with its value and tag
+// converted to the type named valueType.
+func GetTypedValue(node *yaml.Node, valueType string) (*yaml.Node, error) {
+	if node.Kind != yaml.ScalarNode {
+		return nil, fmt.Errorf("type option can only be used with scalar nodes")
+	}
+	vt := getValueType(valueType)
+	f, ok := ValueConverterFactories[vt]
+	if !ok {
+		return nil, fmt.Errorf("type %s is unknown (%d)", valueType, vt)
+	}
+	value, tag, err := f(node.Value)
+	if err != nil {
+		return nil, fmt.Errorf("while converting value to %s: %w", valueType, err)
+	}
+	result := *node
+	result.Value = value
+	result.Tag = tag
+	result.Style = 0
+	return &result, nil
+}
+
+// TypedValue is a scalar value that is written with its own type in the
+// target instead of taking the type of the field it replaces.
+type TypedValue struct {
+	Node *yaml.Node
+}
@@ -0,0 +1,235 @@
+package extras_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const typedResources = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: configuration-map
+data:
+  enabled: "true"
+  port: "8080"
+  ratio: "0.5"
+  version: "1.20"
+  zip: "0123"
+  build: "08"
+  mask: "0x1F"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: target-map
+data:
+  config.json: |
+    {
+      "enabled": false,
+      "port": 80
+    }
+  config.toml: |
+    enabled = false
+    port = 80
+  config.ini: |
+    enabled = false
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replicas: 1
+  template:
+    metadata:
+      labels:
+        version: v1
+`
+
+func TestReplacementValueType(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		source  string
+		target  string
+		path    string
+		options string
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "int in resource",
+			source:  "data.port",
+			target:  "Deployment",
+			path:    "spec.replicas",
+			options: "{type: int}",
+			want:    "  replicas: 8080\n",
+		},
+		{
+			name:    "string keeps quotes",
+			source:  "data.version",
+			target:  "Deployment",
+			path:    "spec.template.metadata.labels.version",
+			options: "{type: string}",
+			want:    "        version: \"1.20\"\n",
+		},
+		{
+			name:    "auto detects bool",
+			source:  "data.enabled",
+			target:  "Deployment",
+			path:    "spec.template.metadata.labels.enabled",
+			options: "{type: auto, create: true}",
+			want:    "        enabled: true\n",
+		},
+		{
+			name:    "bool in json",
+			source:  "data.enabled",
+			target:  "ConfigMap",
+			path:    "data.config\\.json.!!json.enabled",
+			options: "{type: bool}",
+			want:    "\"enabled\": true,",
+		},
+		{
+			name:    "int in json",
+			source:  "data.port",
+			target:  "ConfigMap",
+			path:    "data.config\\.json.!!json.port",
+			options: "{type: int}",
+			want:    "\"port\": 8080\n",
+		},
+		{
+			name:    "untyped keeps target type",
+			source:  "data.port",
+			target:  "ConfigMap",
+			path:    "data.config\\.json.!!json.port",
+			options: "{create: false}",
+			want:    "\"port\": 8080\n",
+		},
+		{
+			name:    "float in toml",
+			source:  "data.ratio",
+			target:  "ConfigMap",
+			path:    "data.config\\.toml.!!toml.ratio",
+			options: "{type: float}",
+			want:    "ratio = 0.5\n",
+		},
+		{
+			name:    "bool in ini",
+			source:  "data.enabled",
+			target:  "ConfigMap",
+			path:    "data.config\\.ini.!!ini.enabled",
+			options: "{type: BOOL}",
+			want:    "enabled = true\n",
+		},
+		{
+			name:    "float keeps its representation",
+			source:  "data.version",
+			target:  "Deployment",
+			path:    "spec.template.metadata.labels.version",
+			options: "{type: float}",
+			want:    "        version: 1.20\n",
+		},
+		{
+			name:    "auto keeps float representation",
+			source:  "data.version",
+			target:  "Deployment",
+			path:    "spec.template.metadata.labels.version",
+			options: "{type: auto}",
+			want:    "        version: 1.20\n",
+		},
+		{
+			name:    "int is decimal",
+			source:  "data.zip",
+			target:  "Deployment",
+			path:    "spec.replicas",
+			options: "{type: int}",
+			want:    "  replicas: 123\n",
+		},
+		{
+			name:    "auto keeps leading zero",
+			source:  "data.zip",
+			target:  "Deployment",
+			path:    "spec.template.metadata.labels.version",
+			options: "{type: auto}",
+			want:    "        version: \"0123\"\n",
+		},
+		{
+			name:    "auto keeps leading zero of non octal",
+			source:  "data.build",
+			target:  "Deployment",
+			path:    "spec.template.metadata.labels.version",
+			options: "{type: auto}",
+			want:    "        version: \"08\"\n",
+		},
+		{
+			name:    "int with leading zero of non octal",
+			source:  "data.build",
+			target:  "Deployment",
+			path:    "spec.replicas",
+			options: "{type: int}",
+			want:    "  replicas: 8\n",
+		},
+		{
+			name:    "auto keeps hexadecimal string",
+			source:  "data.mask",
+			target:  "Deployment",
+			path:    "spec.template.metadata.labels.version",
+			options: "{type: auto}",
+			want:    "        version: \"0x1F\"\n",
+		},
+		{
+			name:    "hexadecimal is not an int",
+			source:  "data.mask",
+			target:  "Deployment",
+			path:    "spec.replicas",
+			options: "{type: int}",
+			wantErr: `value "0x1F" is not an integer`,
+		},
+		{
+			name:    "invalid value",
+			source:  "data.version",
+			target:  "Deployment",
+			path:    "spec.replicas",
+			options: "{type: int}",
+			wantErr: "is not an integer",
+		},
+		{
+			name:    "unknown type",
+			source:  "data.port",
+			target:  "Deployment",
+			path:    "spec.replicas",
+			options: "{type: number}",
+			wantErr: "type number is unknown",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := require.New(t)
+			config := fmt.Sprintf(`
+replacements:
+  - source:
+      kind: ConfigMap
+      name: configuration-map
+      fieldPath: %s
+    targets:
+      - select:
+          kind: %s
+        reject:
+          - name: configuration-map
+        fieldPaths:
+          - %s
+        options: %s
+`, tt.source, tt.target, tt.path, tt.options)
+			got, _, err := runReplacementTransformer(t, config, typedResources)
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			req.Contains(got, tt.want)
+		})
+	}
+}
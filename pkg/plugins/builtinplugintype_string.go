@@ -33,11 +33,15 @@ func _() {
 	_ = x[SopsGenerator-22]
 	_ = x[KCLGenerator-23]
 	_ = x[KCLTransformer-24]
+	_ = x[SettersTransformer-25]
+	_ = x[VarsTransformer-26]
+	_ = x[SopsEncryptTransformer-27]
+	_ = x[SopsDecryptTransformer-28]
 }
 
-const _BuiltinPluginType_name = "UnknownAnnotationsTransformerConfigMapGeneratorIAMPolicyGeneratorHashTransformerImageTagTransformerLabelTransformerNamespaceTransformerPatchJson6902TransformerPatchStrategicMergeTransformerPatchTransformerPrefixSuffixTransformerPrefixTransformerSuffixTransformerReplicaCountTransformerSecretGeneratorValueAddTransformerHelmChartInflationGeneratorReplacementTransformerGitConfigMapGeneratorRemoveTransformerKustomizationGeneratorSopsGeneratorKCLGeneratorKCLTransformer"
+const _BuiltinPluginType_name = "UnknownAnnotationsTransformerConfigMapGeneratorIAMPolicyGeneratorHashTransformerImageTagTransformerLabelTransformerNamespaceTransformerPatchJson6902TransformerPatchStrategicMergeTransformerPatchTransformerPrefixSuffixTransformerPrefixTransformerSuffixTransformerReplicaCountTransformerSecretGeneratorValueAddTransformerHelmChartInflationGeneratorReplacementTransformerGitConfigMapGeneratorRemoveTransformerKustomizationGeneratorSopsGeneratorKCLGeneratorKCLTransformerSettersTransformerVarsTransformerSopsEncryptTransformerSopsDecryptTransformer"
 
-var _BuiltinPluginType_index = [...]uint16{0, 7, 29, 47, 65, 80, 99, 115, 135, 159, 189, 205, 228, 245, 262, 285, 300, 319, 346, 368, 389, 406, 428, 441, 453, 467}
+var _BuiltinPluginType_index = [...]uint16{0, 7, 29, 47, 65, 80, 99, 115, 135, 159, 189, 205, 228, 245, 262, 285, 300, 319, 346, 368, 389, 406, 428, 441, 453, 467, 485, 500, 522, 544}
 
 func (i BuiltinPluginType) String() string {
 	idx := int(i) - 0
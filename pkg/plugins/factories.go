@@ -13,6 +13,7 @@ import (
 	"sigs.k8s.io/kustomize/api/resmap"
 	"sigs.k8s.io/kustomize/api/types"
 	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
 	"sigs.k8s.io/kustomize/kyaml/resid"
 	"sigs.k8s.io/kustomize/kyaml/yaml"
 
@@ -23,6 +24,12 @@ type FunctionConfigConfigurable interface {
 	ConfigureWithFunctionConfig(h *resmap.PluginHelpers, functionConfig *yaml.RNode) error
 }
 
+// ResultsReporter is implemented by plugins that report results (warnings,
+// informative messages) to be added to the ResourceList results.
+type ResultsReporter interface {
+	Results() framework.Results
+}
+
 //go:generate go run golang.org/x/tools/cmd/stringer -type=BuiltinPluginType
 type BuiltinPluginType int
 
@@ -52,6 +59,10 @@ const (
 	SopsGenerator
 	KCLGenerator
 	KCLTransformer
+	SettersTransformer
+	VarsTransformer
+	SopsEncryptTransformer
+	SopsDecryptTransformer
 )
 
 var stringToBuiltinPluginTypeMap map[string]BuiltinPluginType
@@ -125,6 +136,10 @@ var TransformerFactories = map[BuiltinPluginType]func() resmap.TransformerPlugin
 	ValueAddTransformer:            builtins.NewValueAddTransformerPlugin,
 	RemoveTransformer:              extras.NewRemoveTransformerPlugin,
 	KCLTransformer:                 extras.NewKCLTransformerPlugin,
+	SettersTransformer:             extras.NewSettersTransformerPlugin,
+	VarsTransformer:                extras.NewVarsTransformerPlugin,
+	SopsEncryptTransformer:         extras.NewSopsEncryptTransformerPlugin,
+	SopsDecryptTransformer:         extras.NewSopsDecryptTransformerPlugin,
 	// Do not wired SortOrderTransformer as a builtin plugin.
 	// We only want it to be available in the top-level kustomization.
 	// See: https://github.com/kubernetes-sigs/kustomize/issues/3913
@@ -46,4 +46,18 @@ const (
 
 	// Annotation for setting api version of in place generated resources
 	FunctionAnnotationApiVersion = LocalConfigurationAnnotationDomain + "/apiVersion"
+
+	// Marks the resources decrypted by the SopsDecryptTransformer that need to be
+	// encrypted again by the SopsEncryptTransformer
+	FunctionAnnotationSopsReencrypt = LocalConfigurationAnnotationDomain + "/sops-reencrypt"
+
+	// if set to true, the SopsGenerator doesn't verify the message authentication
+	// code of its inline encrypted configuration
+	FunctionAnnotationSopsIgnoreMac = LocalConfigurationAnnotationDomain + "/sops-ignore-mac"
+
+	// if set to true, the function explains its processing in its results
+	FunctionAnnotationDebug = LocalConfigurationAnnotationDomain + "/debug"
+
+	// Environment variable enabling the debug mode of all the functions
+	DebugEnvironmentVariable = "KARMAFUN_DEBUG"
 )
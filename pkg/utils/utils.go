@@ -4,6 +4,7 @@ package utils
 
 import (
 	"fmt"
+	"os"
 	"strconv"
 
 	"sigs.k8s.io/kustomize/api/resmap"
@@ -189,3 +190,14 @@ func ResourceMapFromNodes(nodes []*yaml.RNode) resmap.ResMap {
 	}
 	return result
 }
+
+// IsDebug returns true if the debug mode is enabled, either by the
+// config.karmafun.dev/debug annotation of the function configuration or by the
+// KARMAFUN_DEBUG environment variable.
+func IsDebug(annotations map[string]string) bool {
+	if enabled, err := strconv.ParseBool(annotations[FunctionAnnotationDebug]); err == nil && enabled {
+		return true
+	}
+	enabled, err := strconv.ParseBool(os.Getenv(DebugEnvironmentVariable))
+	return err == nil && enabled
+}
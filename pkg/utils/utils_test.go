@@ -151,3 +151,15 @@ func TestGetPathAnnotationProperties(t *testing.T) {
 		})
 	}
 }
+
+//nolint:paralleltest // uses t.Setenv
+func TestIsDebug(t *testing.T) {
+	req := require.New(t)
+	t.Setenv(utils.DebugEnvironmentVariable, "")
+	req.False(utils.IsDebug(nil))
+	req.False(utils.IsDebug(map[string]string{utils.FunctionAnnotationDebug: "false"}))
+	req.True(utils.IsDebug(map[string]string{utils.FunctionAnnotationDebug: "true"}))
+
+	t.Setenv(utils.DebugEnvironmentVariable, "1")
+	req.True(utils.IsDebug(nil))
+}